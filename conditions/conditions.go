@@ -21,6 +21,7 @@ import (
 
 	apiv1 "github.com/operator-framework/api/pkg/operators/v1"
 	"github.com/operator-framework/operator-lib/internal/utils"
+	"github.com/operator-framework/operator-lib/preflight"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -125,3 +126,25 @@ func GetNamespacedName() (*types.NamespacedName, error) {
 	}
 	return &types.NamespacedName{Name: conditionName, Namespace: operatorNs}, nil
 }
+
+// Permissions returns the permissions needed to get and set the conditions of
+// the operator's Condition CR, as found by GetNamespacedName. They can be
+// checked with preflight.Check.
+func Permissions() ([]preflight.Permission, error) {
+	objKey, err := GetNamespacedName()
+	if err != nil {
+		return nil, err
+	}
+	perm := func(verb, subresource string) preflight.Permission {
+		return preflight.Permission{
+			Verb:        verb,
+			Group:       apiv1.GroupVersion.Group,
+			Resource:    "operatorconditions",
+			Subresource: subresource,
+			Namespace:   objKey.Namespace,
+			Name:        objKey.Name,
+			Feature:     "operator conditions",
+		}
+	}
+	return []preflight.Permission{perm("get", ""), perm("update", "status")}, nil
+}
@@ -254,6 +254,30 @@ var _ = Describe("Condition", func() {
 			Expect(objKey.Namespace).To(BeEquivalentTo("testns"))
 		})
 	})
+
+	Describe("Permissions", func() {
+		It("should error when namespacedName cannot be found", func() {
+			err := os.Unsetenv(operatorCondEnvVar)
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = Permissions()
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("should return the permissions on the operator condition", func() {
+			err := os.Setenv(operatorCondEnvVar, "test")
+			Expect(err).NotTo(HaveOccurred())
+			readNamespace = func() (string, error) {
+				return "testns", nil
+			}
+
+			perms, err := Permissions()
+			Expect(err).NotTo(HaveOccurred())
+			Expect(perms).To(HaveLen(2))
+			Expect(perms[0].String()).To(Equal("get operatorconditions.operators.coreos.com test in testns"))
+			Expect(perms[1].String()).To(Equal("update operatorconditions.operators.coreos.com/status test in testns"))
+		})
+	})
 })
 
 func deleteCondition(ctx context.Context, client client.Client, obj client.Object) {
@@ -0,0 +1,51 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"github.com/operator-framework/operator-lib/predicate"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// WatchAnnotatedDependents registers with blder a watch of each of dependents, enqueueing the owners of the
+// type of owner recorded in their annotations. It is the annotation-based equivalent of Owns:
+//
+//	blder := ctrl.NewControllerManagedBy(mgr).For(&appsv1.ReplicaSet{})
+//	if err := handler.WatchAnnotatedDependents(blder, mgr.GetScheme(), &appsv1.ReplicaSet{},
+//		[]client.Object{&rbacv1.ClusterRole{}, &rbacv1.ClusterRoleBinding{}}); err != nil {
+//		return err
+//	}
+//	return blder.Complete(r)
+//
+// The GroupKind of owner is looked up in scheme. Events are filtered with predicate.DependentPredicate of
+// this library, which ignores the creation of dependents and the changes to their status only, unless
+// opts contain builder.WithPredicates, which replaces it.
+func WatchAnnotatedDependents(blder *builder.Builder, scheme *runtime.Scheme, owner client.Object,
+	dependents []client.Object, opts ...builder.WatchesOption) error {
+	gvk, err := apiutil.GVKForObject(owner, scheme)
+	if err != nil {
+		return err
+	}
+
+	opts = append([]builder.WatchesOption{builder.WithPredicates(predicate.DependentPredicate{})}, opts...)
+	for _, dependent := range dependents {
+		blder.Watches(&source.Kind{Type: dependent}, &EnqueueRequestForAnnotation{Type: gvk.GroupKind()}, opts...)
+	}
+	return nil
+}
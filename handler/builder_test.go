@@ -0,0 +1,143 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"reflect"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/operator-framework/operator-lib/predicate"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	crpredicate "sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// watch is a watch registered by a controller.
+type watch struct {
+	source     *source.Kind
+	handler    handler.EventHandler
+	predicates []crpredicate.Predicate
+}
+
+// watchRecorder is a manager that records the watches of the controllers
+// built with it, which have the source, handler and predicates of each watch
+// injected in turn.
+type watchRecorder struct {
+	manager.Manager
+	scheme  *runtime.Scheme
+	watches []*watch
+}
+
+func (m *watchRecorder) GetScheme() *runtime.Scheme {
+	return m.scheme
+}
+
+func (m *watchRecorder) GetConfig() *rest.Config {
+	return &rest.Config{}
+}
+
+func (m *watchRecorder) Add(manager.Runnable) error {
+	return nil
+}
+
+func (m *watchRecorder) SetFields(i interface{}) error {
+	switch i := i.(type) {
+	case *source.Kind:
+		m.watches = append(m.watches, &watch{source: i})
+	case handler.EventHandler:
+		m.watches[len(m.watches)-1].handler = i
+	case crpredicate.Predicate:
+		w := m.watches[len(m.watches)-1]
+		w.predicates = append(w.predicates, i)
+	}
+	return nil
+}
+
+// watchOf returns the recorded watch of objects of the type of obj.
+func (m *watchRecorder) watchOf(obj client.Object) *watch {
+	for _, w := range m.watches {
+		if reflect.TypeOf(w.source.Type) == reflect.TypeOf(obj) {
+			return w
+		}
+	}
+	return nil
+}
+
+var _ = Describe("WatchAnnotatedDependents", func() {
+	var (
+		mgr   *watchRecorder
+		blder *builder.Builder
+	)
+	build := func() {
+		_, err := blder.Build(reconcile.Func(nil))
+		Expect(err).NotTo(HaveOccurred())
+	}
+
+	BeforeEach(func() {
+		mgr = &watchRecorder{scheme: scheme.Scheme}
+		blder = builder.ControllerManagedBy(mgr).For(&corev1.Pod{}).WithLogger(logf.Log)
+	})
+
+	It("should enqueue owners of the type registered in the scheme", func() {
+		Expect(WatchAnnotatedDependents(blder, scheme.Scheme, &corev1.Pod{},
+			[]client.Object{&rbacv1.ClusterRole{}, &corev1.ConfigMap{}})).To(Succeed())
+		build()
+
+		for _, dependent := range []client.Object{&rbacv1.ClusterRole{}, &corev1.ConfigMap{}} {
+			w := mgr.watchOf(dependent)
+			Expect(w).NotTo(BeNil())
+			Expect(w.handler).To(Equal(&EnqueueRequestForAnnotation{Type: schema.GroupKind{Kind: "Pod"}}))
+		}
+	})
+	It("should filter events with DependentPredicate by default", func() {
+		Expect(WatchAnnotatedDependents(blder, scheme.Scheme, &corev1.Pod{},
+			[]client.Object{&corev1.ConfigMap{}})).To(Succeed())
+		build()
+
+		w := mgr.watchOf(&corev1.ConfigMap{})
+		Expect(w).NotTo(BeNil())
+		Expect(w.predicates).To(ConsistOf(predicate.DependentPredicate{}))
+		dep := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "dependent"}}
+		Expect(w.predicates[0].Create(event.CreateEvent{Object: dep})).To(BeFalse())
+	})
+	It("should replace DependentPredicate with the given predicates", func() {
+		Expect(WatchAnnotatedDependents(blder, scheme.Scheme, &corev1.Pod{},
+			[]client.Object{&corev1.ConfigMap{}},
+			builder.WithPredicates(crpredicate.GenerationChangedPredicate{}))).To(Succeed())
+		build()
+
+		w := mgr.watchOf(&corev1.ConfigMap{})
+		Expect(w).NotTo(BeNil())
+		Expect(w.predicates).To(ConsistOf(crpredicate.GenerationChangedPredicate{}))
+	})
+	It("should fail if the owner is not registered in the scheme", func() {
+		Expect(WatchAnnotatedDependents(blder, runtime.NewScheme(), &corev1.Pod{},
+			[]client.Object{&rbacv1.ClusterRole{}})).NotTo(Succeed())
+	})
+})
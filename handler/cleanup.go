@@ -0,0 +1,289 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// CleanupOption configures CleanupDependents and FindOrphanedDependents.
+type CleanupOption func(*cleanupConfig)
+
+type cleanupConfig struct {
+	keys        []AnnotationKeys
+	propagation metav1.DeletionPropagation
+}
+
+// WithCleanupKeys returns a CleanupOption that sets the annotation keys recording the owners of
+// dependents. Owners recorded with any of keys are considered. It defaults to DefaultAnnotationKeys.
+func WithCleanupKeys(keys ...AnnotationKeys) CleanupOption {
+	return func(c *cleanupConfig) {
+		c.keys = keys
+	}
+}
+
+// WithPropagationPolicy returns a CleanupOption that sets the propagation policy of the deletion of
+// dependents. It defaults to metav1.DeletePropagationBackground.
+func WithPropagationPolicy(policy metav1.DeletionPropagation) CleanupOption {
+	return func(c *cleanupConfig) {
+		c.propagation = policy
+	}
+}
+
+func newCleanupConfig(opts []CleanupOption) *cleanupConfig {
+	c := &cleanupConfig{propagation: metav1.DeletePropagationBackground}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if len(c.keys) == 0 {
+		c.keys = []AnnotationKeys{DefaultAnnotationKeys}
+	}
+	return c
+}
+
+// owners returns the owners recorded in the annotations of dependent with any of the keys of c.
+func (c *cleanupConfig) owners(dependent metav1.Object) []annotatedOwner {
+	var owners []annotatedOwner
+	for _, k := range c.keys {
+		owners = append(owners, k.getAnnotatedOwners(dependent.GetAnnotations())...)
+	}
+	return owners
+}
+
+// CleanupProgress is the progress of CleanupDependents.
+type CleanupProgress struct {
+	// Deleted is the number of dependents deleted by the call.
+	Deleted int
+	// Released is the number of dependents shared with other owners, from whose annotations the owner
+	// was removed instead of deleting them.
+	Released int
+	// Terminating is the number of dependents that were already being deleted, e.g. waiting for their
+	// own finalizers.
+	Terminating int
+	// Done is true once the owner has no dependents left and its finalizer was removed.
+	Done bool
+}
+
+// CleanupDependents cleans up the dependents of owner recorded in their annotations, which the garbage
+// collector ignores, so that the finalizer owner uses for this purpose can be removed. It is meant to be
+// called by the reconciler of owner when owner is being deleted, and returns an error otherwise.
+//
+// The dependents of the kinds in dependents annotated with owner are looked up in all namespaces. A
+// dependent recording owner with a different UID belongs to a previous owner of the same name and is left
+// alone. Dependents only owned by owner are deleted, and owner is removed from the annotations of the
+// dependents it shares with other owners. Once a call finds no dependents left, finalizer is removed from
+// owner, which is updated, and Done is set in the returned progress. Until then, the reconciler should
+// requeue owner.
+func CleanupDependents(ctx context.Context, c client.Client, owner client.Object, finalizer string,
+	dependents []schema.GroupVersionKind, opts ...CleanupOption) (CleanupProgress, error) {
+	var progress CleanupProgress
+	if owner.GetDeletionTimestamp() == nil {
+		return progress, fmt.Errorf("owner %s is not being deleted", client.ObjectKeyFromObject(owner))
+	}
+	config := newCleanupConfig(opts)
+	o, err := annotatedOwnerOf(owner, c, "CleanupDependents")
+	if err != nil {
+		return progress, err
+	}
+
+	for _, gvk := range dependents {
+		list, err := listDependents(ctx, c, gvk)
+		if err != nil {
+			return progress, err
+		}
+		for i := range list.Items {
+			dependent := &list.Items[i]
+			owned, shared := false, false
+			for _, recorded := range config.owners(dependent) {
+				switch {
+				case !recorded.is(o):
+					shared = true
+				case recorded.UID == "" || o.UID == "" || recorded.UID == o.UID:
+					owned = true
+				}
+			}
+			switch {
+			case !owned:
+				continue
+			case shared:
+				if err := releaseDependent(ctx, c, dependent, o, config); err != nil {
+					return progress, err
+				}
+				progress.Released++
+			case dependent.GetDeletionTimestamp() != nil:
+				progress.Terminating++
+			default:
+				if err := deleteDependent(ctx, c, dependent, config); err != nil {
+					return progress, err
+				}
+				progress.Deleted++
+			}
+		}
+	}
+	if progress.Deleted > 0 || progress.Terminating > 0 {
+		return progress, nil
+	}
+
+	if controllerutil.ContainsFinalizer(owner, finalizer) {
+		controllerutil.RemoveFinalizer(owner, finalizer)
+		if err := c.Update(ctx, owner); err != nil {
+			return progress, err
+		}
+	}
+	progress.Done = true
+	return progress, nil
+}
+
+// OrphanedDependent is a dependent none of whose owners recorded in its annotations exist anymore.
+type OrphanedDependent struct {
+	// Dependent is the orphaned dependent.
+	Dependent *unstructured.Unstructured
+	// Owners are the missing owners.
+	Owners []OwnerReference
+}
+
+// OwnerReference identifies an owner recorded in the annotations of a dependent.
+type OwnerReference struct {
+	Type           schema.GroupKind
+	NamespacedName types.NamespacedName
+	// UID is the recorded UID of the owner, if any.
+	UID types.UID
+	// Version is the recorded version of the owner, if any.
+	Version string
+}
+
+// FindOrphanedDependents returns the dependents of the kinds in dependents, in all namespaces, whose
+// owners recorded in their annotations no longer exist, e.g. because their finalizer was removed without
+// cleaning them up. An owner that was recreated with the same name but whose UID differs from the
+// recorded one is missing. Owners whose kind is not registered in the scheme of c are assumed to exist.
+func FindOrphanedDependents(ctx context.Context, c client.Client, dependents []schema.GroupVersionKind,
+	opts ...CleanupOption) ([]OrphanedDependent, error) {
+	config := newCleanupConfig(opts)
+	exists := map[annotatedOwner]bool{}
+
+	var orphans []OrphanedDependent
+	for _, gvk := range dependents {
+		list, err := listDependents(ctx, c, gvk)
+		if err != nil {
+			return nil, err
+		}
+		for i := range list.Items {
+			dependent := &list.Items[i]
+			owners := config.owners(dependent)
+			if len(owners) == 0 {
+				continue
+			}
+			orphan := OrphanedDependent{Dependent: dependent}
+			for _, o := range owners {
+				found, ok := exists[o]
+				if !ok {
+					if found, err = ownerExists(ctx, c, o); err != nil {
+						return nil, err
+					}
+					exists[o] = found
+				}
+				if found {
+					break
+				}
+				orphan.Owners = append(orphan.Owners, OwnerReference{
+					Type:           schema.ParseGroupKind(o.Type),
+					NamespacedName: parseNamespacedName(o.NamespacedName),
+					UID:            o.UID,
+					Version:        o.Version,
+				})
+			}
+			if len(orphan.Owners) == len(owners) {
+				orphans = append(orphans, orphan)
+			}
+		}
+	}
+	return orphans, nil
+}
+
+// annotatedOwnerOf returns the annotatedOwner describing owner, whose kind is looked up in the scheme of
+// c if it is not set, as is the case of typed objects read with a client.
+func annotatedOwnerOf(owner client.Object, c client.Client, caller string) (annotatedOwner, error) {
+	if owner.GetObjectKind().GroupVersionKind().Kind == "" {
+		gvk, err := apiutil.GVKForObject(owner, c.Scheme())
+		if err != nil {
+			return annotatedOwner{}, err
+		}
+		owner = owner.DeepCopyObject().(client.Object)
+		owner.GetObjectKind().SetGroupVersionKind(gvk)
+	}
+	return newAnnotatedOwner(owner, caller)
+}
+
+// listDependents lists the objects of kind gvk in all namespaces.
+func listDependents(ctx context.Context, c client.Client, gvk schema.GroupVersionKind) (*unstructured.UnstructuredList, error) {
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+	if err := c.List(ctx, list); err != nil {
+		return nil, fmt.Errorf("failed to list %s: %w", gvk, err)
+	}
+	return list, nil
+}
+
+// releaseDependent removes owner from the annotations of dependent.
+func releaseDependent(ctx context.Context, c client.Client, dependent *unstructured.Unstructured,
+	owner annotatedOwner, config *cleanupConfig) error {
+	patch := client.MergeFromWithOptions(dependent.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	for _, k := range config.keys {
+		if err := k.removeAnnotatedOwner(dependent, owner); err != nil {
+			return err
+		}
+	}
+	return c.Patch(ctx, dependent, patch)
+}
+
+// deleteDependent deletes dependent, unless it was replaced by another object of the same name.
+func deleteDependent(ctx context.Context, c client.Client, dependent *unstructured.Unstructured,
+	config *cleanupConfig) error {
+	uid := dependent.GetUID()
+	err := c.Delete(ctx, dependent, client.PropagationPolicy(config.propagation),
+		client.Preconditions{UID: &uid})
+	if apierrors.IsNotFound(err) || apierrors.IsConflict(err) {
+		return nil
+	}
+	return err
+}
+
+// ownerExists returns true if owner exists with its recorded UID, or if its kind is not registered in the
+// scheme of c.
+func ownerExists(ctx context.Context, c client.Client, owner annotatedOwner) (bool, error) {
+	obj, err := newObjectOfKind(c.Scheme(), schema.ParseGroupKind(owner.Type))
+	if err != nil {
+		log.Info("Unable to check whether owner exists", "owner", owner.NamespacedName, "type", owner.Type,
+			"error", err.Error())
+		return true, nil
+	}
+	if err := c.Get(ctx, parseNamespacedName(owner.NamespacedName), obj); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return owner.UID == "" || obj.GetUID() == owner.UID, nil
+}
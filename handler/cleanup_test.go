@@ -0,0 +1,153 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Dependent cleanup", func() {
+	const finalizer = "example.com/dependents"
+	var (
+		ctx        context.Context
+		owner      *corev1.Pod
+		other      *corev1.Pod
+		dependents []schema.GroupVersionKind
+	)
+	newOwner := func(name string, uid types.UID) *corev1.Pod {
+		o := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: name, UID: uid}}
+		o.SetGroupVersionKind(schema.GroupVersionKind{Version: "v1", Kind: "Pod"})
+		return o
+	}
+	newRole := func(name string, owners ...client.Object) *rbacv1.ClusterRole {
+		role := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		for _, o := range owners {
+			Expect(AddOwnerAnnotation(o, role)).To(Succeed())
+		}
+		return role
+	}
+	exists := func(c client.Client, obj client.Object) bool {
+		err := c.Get(ctx, client.ObjectKeyFromObject(obj), obj)
+		if apierrors.IsNotFound(err) {
+			return false
+		}
+		Expect(err).NotTo(HaveOccurred())
+		return true
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		owner = newOwner("owner", "uid-1")
+		other = newOwner("other", "uid-2")
+		dependents = []schema.GroupVersionKind{
+			rbacv1.SchemeGroupVersion.WithKind("ClusterRole"),
+			corev1.SchemeGroupVersion.WithKind("ConfigMap"),
+		}
+	})
+
+	Describe("CleanupDependents", func() {
+		It("should delete the dependents of the owner and remove its finalizer", func() {
+			now := metav1.Now()
+			owner.DeletionTimestamp = &now
+			owner.Finalizers = []string{finalizer}
+			owned := newRole("owned", owner)
+			shared := newRole("shared", owner, other)
+			unrelated := newRole("unrelated", other)
+			stale := newRole("stale", newOwner("owner", "uid-0"))
+			cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "other-ns", Name: "cm"}}
+			Expect(SetOwnerAnnotations(owner, cm)).To(Succeed())
+			c := fake.NewClientBuilder().WithObjects(owner, other, owned, shared, unrelated, stale, cm).Build()
+
+			// Typed objects read with a client have no kind.
+			current := &corev1.Pod{}
+			Expect(c.Get(ctx, client.ObjectKeyFromObject(owner), current)).To(Succeed())
+			current.SetGroupVersionKind(schema.GroupVersionKind{})
+
+			progress, err := CleanupDependents(ctx, c, current, finalizer, dependents)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(progress).To(Equal(CleanupProgress{Deleted: 2, Released: 1}))
+			Expect(exists(c, owned)).To(BeFalse())
+			Expect(exists(c, cm)).To(BeFalse())
+			Expect(exists(c, unrelated)).To(BeTrue())
+			Expect(exists(c, stale)).To(BeTrue())
+			Expect(exists(c, shared)).To(BeTrue())
+			Expect(shared.Annotations).To(HaveKeyWithValue(OwnersAnnotation,
+				`[{"type":"Pod","namespacedName":"ns/other","uid":"uid-2","version":"v1"}]`))
+
+			progress, err = CleanupDependents(ctx, c, current, finalizer, dependents)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(progress).To(Equal(CleanupProgress{Done: true}))
+			Expect(c.Get(ctx, client.ObjectKeyFromObject(owner), current)).To(Succeed())
+			Expect(current.Finalizers).To(BeEmpty())
+		})
+		It("should use the configured keys", func() {
+			now := metav1.Now()
+			owner.DeletionTimestamp = &now
+			keys := AnnotationKeysWithPrefix("example.com")
+			role := newRole("default", owner)
+			prefixed := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "prefixed"}}
+			Expect(keys.SetOwnerAnnotations(owner, prefixed)).To(Succeed())
+			c := fake.NewClientBuilder().WithObjects(role, prefixed).Build()
+
+			progress, err := CleanupDependents(ctx, c, owner, finalizer, dependents, WithCleanupKeys(keys),
+				WithPropagationPolicy(metav1.DeletePropagationForeground))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(progress.Deleted).To(Equal(1))
+			Expect(exists(c, prefixed)).To(BeFalse())
+			Expect(exists(c, role)).To(BeTrue())
+		})
+		It("should fail if the owner is not being deleted", func() {
+			c := fake.NewClientBuilder().WithObjects(owner).Build()
+			_, err := CleanupDependents(ctx, c, owner, finalizer, dependents)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	Describe("FindOrphanedDependents", func() {
+		It("should return the dependents whose owners are all gone", func() {
+			recreated := newOwner("recreated", "uid-3")
+			deleted := newOwner("deleted", "uid-4")
+			alive := newRole("alive", owner, deleted)
+			orphan := newRole("orphan", deleted)
+			stale := newRole("stale", newOwner("recreated", "uid-0"))
+			unannotated := newRole("unannotated")
+			current := recreated.DeepCopy()
+			c := fake.NewClientBuilder().WithObjects(owner, current, alive, orphan, stale, unannotated).Build()
+
+			orphans, err := FindOrphanedDependents(ctx, c, dependents)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(orphans).To(HaveLen(2))
+			Expect(orphans[0].Dependent.GetName()).To(Equal("orphan"))
+			Expect(orphans[0].Owners).To(Equal([]OwnerReference{{
+				Type:           schema.GroupKind{Kind: "Pod"},
+				NamespacedName: types.NamespacedName{Namespace: "ns", Name: "deleted"},
+				UID:            "uid-4",
+				Version:        "v1",
+			}}))
+			Expect(orphans[1].Dependent.GetName()).To(Equal("stale"))
+		})
+	})
+})
@@ -15,10 +15,10 @@
 package handler
 
 import (
-	"fmt"
 	"strings"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/util/workqueue"
@@ -45,7 +45,8 @@ const (
 // EnqueueRequestForAnnotation enqueues Request containing the Name and Namespace specified in the
 // annotations of the object that is the source of the Event. The source of the event triggers reconciliation
 // of the parent resource which is identified by annotations. `NamespacedNameAnnotation` and
-// `TypeAnnotation` together uniquely identify an owner resource to reconcile.
+// `TypeAnnotation` together uniquely identify an owner resource to reconcile. An object shared by several
+// owners lists them in `OwnersAnnotation`, and a Request is enqueued for each owner of the watched type.
 //
 // handler.EnqueueRequestForAnnotation can be used to trigger reconciliation of resources which are
 // cross-referenced.  This allows a namespace-scoped dependent to trigger reconciliation of an owner
@@ -62,7 +63,7 @@ const (
 //		Type: &rbacv1.ClusterRole{}},
 //
 //		// Enqueue ReplicaSet reconcile requests using the namespacedName annotation value in the request.
-//		&handler.EnqueueRequestForAnnotation{schema.GroupKind{Group:"apps", Kind:"ReplicaSet"}}); err != nil {
+//		&handler.EnqueueRequestForAnnotation{Type: schema.GroupKind{Group:"apps", Kind:"ReplicaSet"}}); err != nil {
 //			entryLog.Error(err, "unable to watch ClusterRole")
 //			os.Exit(1)
 //		}
@@ -79,62 +80,111 @@ const (
 // the garbage collector still respects the scope restrictions. For example,
 // if a parent creates a child resource across scopes not supported by owner references, it becomes the
 // responsibility of the reconciler to clean up the child resource. Hence, the resource utilizing this handler
-// SHOULD ALWAYS BE IMPLEMENTED WITH A FINALIZER.
+// SHOULD ALWAYS BE IMPLEMENTED WITH A FINALIZER. CleanupDependents deletes the dependents of an owner before
+// removing its finalizer, and FindOrphanedDependents finds the dependents left behind by owners deleted
+// without it.
 type EnqueueRequestForAnnotation struct {
 	Type schema.GroupKind
+
+	// Types are other types of owners to enqueue, in addition to Type. Recorded types match a type if they
+	// are equal to it regardless of case or, when a RESTMapper is injected by Controller.Watch, if they map
+	// to it as a kind or resource name. Short names are mapped if the RESTMapper expands them, as those
+	// created with restmapper.NewShortcutExpander do.
+	Types []schema.GroupKind
+
+	// Keys are the sets of annotation keys recording the owners. Owners recorded with any of them are
+	// enqueued, so that dependents annotated with old and new keys are both handled while migrating from
+	// one set of keys to another. It defaults to DefaultAnnotationKeys.
+	Keys []AnnotationKeys
+
+	// OwnerUIDCheck sets what to do with an owner whose UID recorded in the annotations differs from the
+	// UID of the current owner, e.g. because the owner was deleted and recreated with the same name. It
+	// defaults to IgnoreOwnerUID. Other checks look the owner up in the cache and scheme injected by
+	// Controller.Watch.
+	OwnerUIDCheck OwnerUIDCheck
+
+	// Strict drops the owners whose namespace or name recorded in the annotations is not a valid DNS name,
+	// as ParseOwnerAnnotations does, instead of enqueueing them. Malformed annotations are reported with a
+	// metric and a log either way.
+	Strict bool
+
+	reader client.Reader
+	scheme *runtime.Scheme
+	mapper *typeMapper
 }
 
 var _ crtHandler.EventHandler = &EnqueueRequestForAnnotation{}
 
 // Create implements EventHandler
 func (e *EnqueueRequestForAnnotation) Create(evt event.CreateEvent, q workqueue.RateLimitingInterface) {
-	if ok, req := e.getAnnotationRequests(evt.Object); ok {
+	for _, req := range e.getAnnotationRequests(evt.Object) {
 		q.Add(req)
 	}
 }
 
 // Update implements EventHandler
 func (e *EnqueueRequestForAnnotation) Update(evt event.UpdateEvent, q workqueue.RateLimitingInterface) {
-	if ok, req := e.getAnnotationRequests(evt.ObjectOld); ok {
+	for _, req := range e.getAnnotationRequests(evt.ObjectOld) {
 		q.Add(req)
 	}
-	if ok, req := e.getAnnotationRequests(evt.ObjectNew); ok {
+	for _, req := range e.getAnnotationRequests(evt.ObjectNew) {
 		q.Add(req)
 	}
 }
 
 // Delete implements EventHandler
 func (e *EnqueueRequestForAnnotation) Delete(evt event.DeleteEvent, q workqueue.RateLimitingInterface) {
-	if ok, req := e.getAnnotationRequests(evt.Object); ok {
+	for _, req := range e.getAnnotationRequests(evt.Object) {
 		q.Add(req)
 	}
 }
 
 // Generic implements EventHandler
 func (e *EnqueueRequestForAnnotation) Generic(evt event.GenericEvent, q workqueue.RateLimitingInterface) {
-	if ok, req := e.getAnnotationRequests(evt.Object); ok {
+	for _, req := range e.getAnnotationRequests(evt.Object) {
 		q.Add(req)
 	}
 }
 
-// getAnnotationRequests checks if the provided object has the annotations so as to enqueue the reconcile request.
-func (e *EnqueueRequestForAnnotation) getAnnotationRequests(object metav1.Object) (bool, reconcile.Request) {
+// getAnnotationRequests returns a reconcile request for each owner of one of the types of e recorded in
+// the annotations of the provided object.
+func (e *EnqueueRequestForAnnotation) getAnnotationRequests(object metav1.Object) []reconcile.Request {
 	if len(object.GetAnnotations()) == 0 {
-		return false, reconcile.Request{}
+		return nil
 	}
 
-	if typeString, ok := object.GetAnnotations()[TypeAnnotation]; ok && typeString == e.Type.String() {
-		namespacedNameString, ok := object.GetAnnotations()[NamespacedNameAnnotation]
-		if !ok {
-			log.Info("Unable to find namespaced name annotation for resource", "resource", object)
-		}
-		if strings.TrimSpace(namespacedNameString) == "" {
-			return false, reconcile.Request{}
+	keys := e.Keys
+	if len(keys) == 0 {
+		keys = []AnnotationKeys{DefaultAnnotationKeys}
+	}
+
+	var reqs []reconcile.Request
+	seen := map[reconcile.Request]bool{}
+	for _, k := range keys {
+		for _, owner := range k.getAnnotatedOwners(object.GetAnnotations()) {
+			if strings.TrimSpace(owner.NamespacedName) == "" {
+				continue
+			}
+			ownerType, ok := e.matchType(owner)
+			if !ok {
+				continue
+			}
+			ref, err := owner.reference()
+			if err != nil {
+				reportMalformedAnnotation(err)
+				if e.Strict {
+					continue
+				}
+				ref.NamespacedName = parseNamespacedName(owner.NamespacedName)
+			}
+			req := reconcile.Request{NamespacedName: ref.NamespacedName}
+			if !seen[req] && e.ownerUIDMatches(object, owner, ownerType, req) {
+				seen[req] = true
+				reqs = append(reqs, req)
+			}
 		}
-		nsn := parseNamespacedName(namespacedNameString)
-		return true, reconcile.Request{NamespacedName: nsn}
 	}
-	return false, reconcile.Request{}
+	return reqs
 }
 
 // parseNamespacedName parses the provided string to extract the namespace and name into a
@@ -154,16 +204,17 @@ func parseNamespacedName(namespacedNameString string) types.NamespacedName {
 // the values obtained from owner. The object gets the annotations from owner's namespace, name, group
 // and kind. In other terms, object can be said to be the dependent having annotations from the owner.
 // When a watch is set on the object, the annotations help to identify the owner and trigger reconciliation.
+// The UID and version of owner, if any, are recorded in 'UIDAnnotation' and 'VersionAnnotation'.
 // Annotations are ALWAYS overwritten.
 func SetOwnerAnnotations(owner, object client.Object) error {
-	if owner.GetName() == "" {
-		return fmt.Errorf("%T does not have a name, cannot call SetOwnerAnnotations", owner)
-	}
-
-	ownerGK := owner.GetObjectKind().GroupVersionKind().GroupKind()
+	return DefaultAnnotationKeys.SetOwnerAnnotations(owner, object)
+}
 
-	if ownerGK.Kind == "" {
-		return fmt.Errorf("Owner %s Kind not found, cannot call SetOwnerAnnotations", owner.GetName())
+// SetOwnerAnnotations is like the SetOwnerAnnotations function, but uses the keys of k.
+func (k AnnotationKeys) SetOwnerAnnotations(owner, object client.Object) error {
+	o, err := newAnnotatedOwner(owner, "SetOwnerAnnotations")
+	if err != nil {
+		return err
 	}
 
 	annotations := object.GetAnnotations()
@@ -171,8 +222,20 @@ func SetOwnerAnnotations(owner, object client.Object) error {
 		annotations = map[string]string{}
 	}
 
-	annotations[NamespacedNameAnnotation] = fmt.Sprintf("%s/%s", owner.GetNamespace(), owner.GetName())
-	annotations[TypeAnnotation] = ownerGK.String()
+	annotations[k.NamespacedName] = o.NamespacedName
+	annotations[k.Type] = o.Type
+	setOrDelete := func(key, value string) {
+		if key == "" {
+			return
+		}
+		if value != "" {
+			annotations[key] = value
+		} else {
+			delete(annotations, key)
+		}
+	}
+	setOrDelete(k.UID, string(o.UID))
+	setOrDelete(k.Version, o.Version)
 
 	object.SetAnnotations(annotations)
 
@@ -0,0 +1,240 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	crtHandler "sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// OwnerLabel is a label whose value encodes the name and namespace of a resource to reconcile when a
+	// resource containing this label changes. Values are of the form `<namespace>_<name>` for
+	// namespace-scoped owners and `<name>` for cluster-scoped owners, unless they are not valid label
+	// values, e.g. because they are too long, in which case they are truncated and suffixed with a hash.
+	OwnerLabel = "operator-sdk/primary-resource"
+	// OwnerTypeLabel is a label whose value encodes the group and kind of a resource to reconcile when a
+	// resource containing this label changes. Values are of the form of those of TypeAnnotation, truncated
+	// and suffixed with a hash if they are not valid label values.
+	OwnerTypeLabel = "operator-sdk/primary-resource-type"
+)
+
+// labelValueHashLength is the length of the hash suffixing label values that had to be changed.
+const labelValueHashLength = 10
+
+// invalidLabelValueChars matches the characters that cannot appear in label values.
+var invalidLabelValueChars = regexp.MustCompile(`[^-A-Za-z0-9_.]`)
+
+// EnqueueRequestForLabel enqueues Requests for the owners identified by the labels of the object that is
+// the source of the Event. It is like EnqueueRequestForAnnotation, except that the owner is also encoded in
+// labels, so that the dependents of the owners of a type, or of a given owner, can be selected with
+// OwnerTypeSelector and OwnerSelector. This lets the watches and caches of dependents be restricted to the
+// dependents of an operator instead of holding every object of their types.
+//
+// Label values are limited in length and characters, so OwnerLabel and OwnerTypeLabel might not hold the
+// full reference to the owner. SetOwnerLabels also records it in the annotations of the dependent, which
+// EnqueueRequestForLabel reads. Objects whose labels do not match their annotations are ignored.
+type EnqueueRequestForLabel struct {
+	// Type is the type of the owners to enqueue. Types recorded in the annotations match it as they match
+	// the types of EnqueueRequestForAnnotation.
+	Type schema.GroupKind
+
+	// Keys are the sets of keys identifying the owner, as set by the SetOwnerLabels method of
+	// AnnotationKeys. Their NamespacedName and Type keys are used both as label and annotation keys. It
+	// defaults to DefaultAnnotationKeys, whose label keys are OwnerLabel and OwnerTypeLabel.
+	Keys []AnnotationKeys
+
+	mapper *typeMapper
+}
+
+var _ crtHandler.EventHandler = &EnqueueRequestForLabel{}
+
+// Create implements EventHandler
+func (e *EnqueueRequestForLabel) Create(evt event.CreateEvent, q workqueue.RateLimitingInterface) {
+	for _, req := range e.getLabelRequests(evt.Object) {
+		q.Add(req)
+	}
+}
+
+// Update implements EventHandler
+func (e *EnqueueRequestForLabel) Update(evt event.UpdateEvent, q workqueue.RateLimitingInterface) {
+	for _, req := range e.getLabelRequests(evt.ObjectOld) {
+		q.Add(req)
+	}
+	for _, req := range e.getLabelRequests(evt.ObjectNew) {
+		q.Add(req)
+	}
+}
+
+// Delete implements EventHandler
+func (e *EnqueueRequestForLabel) Delete(evt event.DeleteEvent, q workqueue.RateLimitingInterface) {
+	for _, req := range e.getLabelRequests(evt.Object) {
+		q.Add(req)
+	}
+}
+
+// Generic implements EventHandler
+func (e *EnqueueRequestForLabel) Generic(evt event.GenericEvent, q workqueue.RateLimitingInterface) {
+	for _, req := range e.getLabelRequests(evt.Object) {
+		q.Add(req)
+	}
+}
+
+// getLabelRequests returns a reconcile request for the owner of type e.Type identified by the labels of the
+// provided object, whose full reference is read from its annotations.
+func (e *EnqueueRequestForLabel) getLabelRequests(object client.Object) []reconcile.Request {
+	keys := e.Keys
+	if len(keys) == 0 {
+		keys = []AnnotationKeys{DefaultAnnotationKeys}
+	}
+
+	var reqs []reconcile.Request
+	seen := map[reconcile.Request]bool{}
+	for _, k := range keys {
+		req, ok := e.getLabelRequest(k, object)
+		if ok && !seen[req] {
+			seen[req] = true
+			reqs = append(reqs, req)
+		}
+	}
+	return reqs
+}
+
+// getLabelRequest returns a reconcile request for the owner of type e.Type identified by the labels of the
+// provided object with the keys of k, if any.
+func (e *EnqueueRequestForLabel) getLabelRequest(k AnnotationKeys, object client.Object) (reconcile.Request, bool) {
+	objectLabels := object.GetLabels()
+	ownerValue, ok := objectLabels[k.NamespacedName]
+	if !ok {
+		return reconcile.Request{}, false
+	}
+
+	matched := objectLabels[k.Type] == labelValue(e.Type.String())
+	for _, owner := range k.getAnnotatedOwners(object.GetAnnotations()) {
+		// the type label must match the recorded type, which must match e.Type
+		if objectLabels[k.Type] != labelValue(owner.Type) || strings.TrimSpace(owner.NamespacedName) == "" {
+			continue
+		}
+		if _, ok := matchOwnerType([]schema.GroupKind{e.Type}, e.mapper, owner); !ok {
+			continue
+		}
+		matched = true
+		nsn := parseNamespacedName(owner.NamespacedName)
+		if ownerLabelValue(nsn.Namespace, nsn.Name) == ownerValue {
+			return reconcile.Request{NamespacedName: nsn}, true
+		}
+	}
+	if matched {
+		log.Info("Unable to find the owner identified by labels in the annotations of resource",
+			"name", object.GetName(), "namespace", object.GetNamespace(), "type", e.Type.String(),
+			"owner", ownerValue, "label", k.NamespacedName)
+	}
+	return reconcile.Request{}, false
+}
+
+// SetOwnerLabels sets 'OwnerLabel' and 'OwnerTypeLabel' on object to identify owner, and records owner in
+// the annotations of object with SetOwnerAnnotations. Labels are ALWAYS overwritten.
+func SetOwnerLabels(owner, object client.Object) error {
+	return DefaultAnnotationKeys.SetOwnerLabels(owner, object)
+}
+
+// SetOwnerLabels is like the SetOwnerLabels function, but uses the NamespacedName and Type keys of k as
+// label keys, and records owner in the annotations of object with the keys of k.
+func (k AnnotationKeys) SetOwnerLabels(owner, object client.Object) error {
+	o, err := newAnnotatedOwner(owner, "SetOwnerLabels")
+	if err != nil {
+		return err
+	}
+	if err := k.SetOwnerAnnotations(owner, object); err != nil {
+		return err
+	}
+
+	objectLabels := object.GetLabels()
+	if objectLabels == nil {
+		objectLabels = map[string]string{}
+	}
+	objectLabels[k.NamespacedName] = ownerLabelValue(owner.GetNamespace(), owner.GetName())
+	objectLabels[k.Type] = labelValue(o.Type)
+	object.SetLabels(objectLabels)
+	return nil
+}
+
+// OwnerTypeSelector returns a selector of the objects whose labels identify an owner of type ownerType.
+func OwnerTypeSelector(ownerType schema.GroupKind) labels.Selector {
+	return DefaultAnnotationKeys.OwnerTypeSelector(ownerType)
+}
+
+// OwnerTypeSelector is like the OwnerTypeSelector function, but selects the labels set with the keys of k.
+func (k AnnotationKeys) OwnerTypeSelector(ownerType schema.GroupKind) labels.Selector {
+	return labels.SelectorFromValidatedSet(labels.Set{k.Type: labelValue(ownerType.String())})
+}
+
+// OwnerSelector returns a selector of the objects whose labels identify owner. Since label values might be
+// hashed, objects whose labels identify other owners could be selected, although it is unlikely.
+func OwnerSelector(owner client.Object) (labels.Selector, error) {
+	return DefaultAnnotationKeys.OwnerSelector(owner)
+}
+
+// OwnerSelector is like the OwnerSelector function, but selects the labels set with the keys of k.
+func (k AnnotationKeys) OwnerSelector(owner client.Object) (labels.Selector, error) {
+	o, err := newAnnotatedOwner(owner, "OwnerSelector")
+	if err != nil {
+		return nil, err
+	}
+	return labels.SelectorFromValidatedSet(labels.Set{
+		k.NamespacedName: ownerLabelValue(owner.GetNamespace(), owner.GetName()),
+		k.Type:           labelValue(o.Type),
+	}), nil
+}
+
+// ownerLabelValue returns the value of OwnerLabel identifying the owner namespace/name.
+func ownerLabelValue(namespace, name string) string {
+	if namespace == "" {
+		return labelValue(name)
+	}
+	return labelValue(fmt.Sprintf("%s_%s", namespace, name))
+}
+
+// labelValue returns value if it is a valid label value. Otherwise, it returns value without its invalid
+// characters, truncated and suffixed with a hash of value, so that distinct values remain distinct.
+func labelValue(value string) string {
+	if len(validation.IsValidLabelValue(value)) == 0 {
+		return value
+	}
+	sum := sha256.Sum256([]byte(value))
+	hash := hex.EncodeToString(sum[:])[:labelValueHashLength]
+
+	prefix := invalidLabelValueChars.ReplaceAllString(value, "-")
+	if maxLength := validation.LabelValueMaxLength - labelValueHashLength - 1; len(prefix) > maxLength {
+		prefix = prefix[:maxLength]
+	}
+	prefix = strings.Trim(prefix, "-_.")
+	if prefix == "" {
+		return hash
+	}
+	return prefix + "-" + hash
+}
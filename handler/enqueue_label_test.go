@@ -0,0 +1,205 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllertest"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var _ = Describe("EnqueueRequestForLabel", func() {
+	var (
+		q        workqueue.RateLimitingInterface
+		instance EnqueueRequestForLabel
+		role     *rbacv1.ClusterRole
+		owner    *corev1.Pod
+	)
+	drainQueue := func() []interface{} {
+		var items []interface{}
+		for q.Len() > 0 {
+			i, _ := q.Get()
+			items = append(items, i)
+			q.Done(i)
+		}
+		return items
+	}
+
+	BeforeEach(func() {
+		q = controllertest.Queue{Interface: workqueue.New()}
+		instance = EnqueueRequestForLabel{Type: schema.GroupKind{Group: "apps", Kind: "ReplicaSet"}}
+		role = &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "role"}}
+		owner = &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "owner"}}
+		owner.SetGroupVersionKind(schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "ReplicaSet"})
+	})
+
+	Describe("SetOwnerLabels", func() {
+		It("should set the owner labels and annotations", func() {
+			Expect(SetOwnerLabels(owner, role)).To(Succeed())
+			Expect(role.Labels).To(Equal(map[string]string{
+				OwnerLabel:     "ns_owner",
+				OwnerTypeLabel: "ReplicaSet.apps",
+			}))
+			Expect(role.Annotations).To(HaveKeyWithValue(NamespacedNameAnnotation, "ns/owner"))
+			Expect(role.Annotations).To(HaveKeyWithValue(TypeAnnotation, "ReplicaSet.apps"))
+		})
+		It("should hash the values that are not valid label values", func() {
+			owner.Name = strings.Repeat("a", 70)
+			Expect(SetOwnerLabels(owner, role)).To(Succeed())
+			value := role.Labels[OwnerLabel]
+			Expect(validation.IsValidLabelValue(value)).To(BeEmpty())
+			Expect(value).To(HavePrefix("ns_aaa"))
+
+			other := owner.DeepCopy()
+			other.Name = strings.Repeat("a", 71)
+			Expect(SetOwnerLabels(other, role)).To(Succeed())
+			Expect(role.Labels[OwnerLabel]).NotTo(Equal(value))
+		})
+		It("should return an error when the owner Kind is not set", func() {
+			owner.SetGroupVersionKind(schema.GroupVersionKind{})
+			Expect(SetOwnerLabels(owner, role)).NotTo(Succeed())
+		})
+	})
+
+	It("should enqueue a Request for the owner identified by the labels", func() {
+		owner.Name = strings.Repeat("a", 70)
+		Expect(SetOwnerLabels(owner, role)).To(Succeed())
+		instance.Create(event.CreateEvent{Object: role}, q)
+		Expect(drainQueue()).To(ConsistOf(reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: "ns", Name: owner.Name},
+		}))
+	})
+	It("should enqueue a Request for a cluster-scoped owner", func() {
+		owner.Namespace = ""
+		Expect(SetOwnerLabels(owner, role)).To(Succeed())
+		Expect(role.Labels).To(HaveKeyWithValue(OwnerLabel, "owner"))
+		instance.Delete(event.DeleteEvent{Object: role}, q)
+		Expect(drainQueue()).To(ConsistOf(reconcile.Request{NamespacedName: types.NamespacedName{Name: "owner"}}))
+	})
+	It("should not enqueue a Request for an owner of another type", func() {
+		Expect(SetOwnerLabels(owner, role)).To(Succeed())
+		instance.Type = schema.GroupKind{Kind: "Pod"}
+		instance.Create(event.CreateEvent{Object: role}, q)
+		Expect(q.Len()).To(Equal(0))
+	})
+	It("should not enqueue a Request when the labels do not match the annotations", func() {
+		Expect(SetOwnerLabels(owner, role)).To(Succeed())
+		role.Labels[OwnerLabel] = "ns_other"
+		instance.Generic(event.GenericEvent{Object: role}, q)
+		Expect(q.Len()).To(Equal(0))
+	})
+
+	Describe("type matching", func() {
+		labelWithType := func(ownerType string) {
+			role.Labels = map[string]string{OwnerLabel: "ns_owner", OwnerTypeLabel: labelValue(ownerType)}
+			role.Annotations = map[string]string{NamespacedNameAnnotation: "ns/owner", TypeAnnotation: ownerType}
+		}
+		request := reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "ns", Name: "owner"}}
+
+		It("should match types regardless of case", func() {
+			labelWithType("replicaset.APPS")
+			instance.Create(event.CreateEvent{Object: role}, q)
+			Expect(drainQueue()).To(ConsistOf(request))
+		})
+		It("should match resource names with a RESTMapper", func() {
+			labelWithType("replicasets.apps")
+			instance.Create(event.CreateEvent{Object: role}, q)
+			Expect(drainQueue()).To(BeEmpty())
+
+			mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{appsv1.SchemeGroupVersion})
+			mapper.Add(appsv1.SchemeGroupVersion.WithKind("ReplicaSet"), meta.RESTScopeNamespace)
+			Expect(instance.InjectMapper(mapper)).To(Succeed())
+			instance.Create(event.CreateEvent{Object: role}, q)
+			Expect(drainQueue()).To(ConsistOf(request))
+		})
+		It("should not match when the type label does not match the annotations", func() {
+			labelWithType("replicaset.APPS")
+			role.Labels[OwnerTypeLabel] = "Pod"
+			instance.Create(event.CreateEvent{Object: role}, q)
+			Expect(drainQueue()).To(BeEmpty())
+		})
+	})
+
+	Describe("AnnotationKeys", func() {
+		var keys AnnotationKeys
+		BeforeEach(func() {
+			keys = AnnotationKeysWithPrefix("example.com")
+		})
+
+		It("should label and annotate dependents with its own keys", func() {
+			Expect(keys.SetOwnerLabels(owner, role)).To(Succeed())
+			Expect(role.Labels).To(Equal(map[string]string{
+				"example.com/primary-resource":      "ns_owner",
+				"example.com/primary-resource-type": "ReplicaSet.apps",
+			}))
+			Expect(role.Annotations).To(HaveKeyWithValue("example.com/primary-resource", "ns/owner"))
+			Expect(role.Annotations).NotTo(HaveKey(NamespacedNameAnnotation))
+
+			selector, err := keys.OwnerSelector(owner)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(selector.Matches(labels.Set(role.Labels))).To(BeTrue())
+			Expect(keys.OwnerTypeSelector(instance.Type).Matches(labels.Set(role.Labels))).To(BeTrue())
+			Expect(OwnerTypeSelector(instance.Type).Matches(labels.Set(role.Labels))).To(BeFalse())
+		})
+		It("should not collide with the default keys", func() {
+			other := owner.DeepCopy()
+			other.Name = "other"
+			Expect(keys.SetOwnerLabels(owner, role)).To(Succeed())
+			Expect(SetOwnerLabels(other, role)).To(Succeed())
+
+			instance.Create(event.CreateEvent{Object: role}, q)
+			Expect(drainQueue()).To(ConsistOf(reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: "ns", Name: "other"},
+			}))
+
+			instance.Keys = []AnnotationKeys{keys}
+			instance.Create(event.CreateEvent{Object: role}, q)
+			Expect(drainQueue()).To(ConsistOf(reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: "ns", Name: "owner"},
+			}))
+		})
+	})
+
+	Describe("Selectors", func() {
+		It("should select the dependents of an owner", func() {
+			Expect(SetOwnerLabels(owner, role)).To(Succeed())
+			selector, err := OwnerSelector(owner)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(selector.Matches(labels.Set(role.Labels))).To(BeTrue())
+			Expect(OwnerTypeSelector(instance.Type).Matches(labels.Set(role.Labels))).To(BeTrue())
+
+			other := owner.DeepCopy()
+			other.Name = "other"
+			selector, err = OwnerSelector(other)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(selector.Matches(labels.Set(role.Labels))).To(BeFalse())
+			Expect(OwnerTypeSelector(schema.GroupKind{Kind: "Pod"}).Matches(labels.Set(role.Labels))).To(BeFalse())
+		})
+	})
+})
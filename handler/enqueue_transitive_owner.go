@@ -0,0 +1,295 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilcache "k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	crtHandler "sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/runtime/inject"
+)
+
+// DefaultMaxOwnerDepth is the default number of levels of owners walked by
+// EnqueueRequestForTransitiveOwner.
+const DefaultMaxOwnerDepth = 5
+
+const (
+	// ownerMemoSize is the number of owners whose walks are remembered by EnqueueRequestForTransitiveOwner.
+	ownerMemoSize = 4096
+	// ownerMemoTTL is how long the walk from an owner is remembered, so that changes to the owners of
+	// owners are eventually taken into account.
+	ownerMemoTTL = time.Minute
+)
+
+// EnqueueRequestForTransitiveOwner enqueues Requests for the owners of type Type found by walking the owner
+// references of the object that is the source of the Event, and of its owners, up to MaxDepth levels. Unlike
+// controller-runtime's handler.EnqueueRequestForOwner, which only looks at the owners of the object itself,
+// it lets a Pod created by a ReplicaSet owned by a Deployment owned by a custom resource trigger the
+// reconciliation of the custom resource:
+//
+//	if err := c.Watch(&source.Kind{Type: &corev1.Pod{}},
+//		&handler.EnqueueRequestForTransitiveOwner{Type: schema.GroupKind{Group: "example.com", Kind: "App"}}); err != nil {
+//		return err
+//	}
+//
+// Owners are looked up in the cache and scheme injected by Controller.Watch, so the types of the
+// intermediate owners must be registered in the scheme, and are cached if they were not already. The
+// owners reached from an owner are remembered for a minute, so that the events of the many dependents of an
+// owner do not each walk its owners.
+type EnqueueRequestForTransitiveOwner struct {
+	// Type is the type of the owners to enqueue.
+	Type schema.GroupKind
+
+	// MaxDepth is the number of levels of owners walked, 1 being the owners of the object itself. It
+	// defaults to DefaultMaxOwnerDepth.
+	MaxDepth int
+
+	// IsController only follows the owner references whose Controller field is set.
+	IsController bool
+
+	// FollowAnnotations also follows the owners recorded in the annotations of objects with Keys, as
+	// EnqueueRequestForAnnotation does.
+	FollowAnnotations bool
+
+	// Keys are the sets of annotation keys followed when FollowAnnotations is set. It defaults to
+	// DefaultAnnotationKeys.
+	Keys []AnnotationKeys
+
+	reader client.Reader
+	scheme *runtime.Scheme
+	memo   *utilcache.LRUExpireCache
+}
+
+var (
+	_ crtHandler.EventHandler = &EnqueueRequestForTransitiveOwner{}
+	_ inject.Cache            = &EnqueueRequestForTransitiveOwner{}
+	_ inject.Scheme           = &EnqueueRequestForTransitiveOwner{}
+)
+
+// InjectCache is called by Controller.Watch to provide the cache used to look owners up.
+func (e *EnqueueRequestForTransitiveOwner) InjectCache(c cache.Cache) error {
+	e.setReader(c)
+	return nil
+}
+
+// InjectScheme is called by Controller.Watch to provide the scheme used to create owner objects.
+func (e *EnqueueRequestForTransitiveOwner) InjectScheme(s *runtime.Scheme) error {
+	e.scheme = s
+	return nil
+}
+
+func (e *EnqueueRequestForTransitiveOwner) setReader(r client.Reader) {
+	e.reader = r
+	e.memo = utilcache.NewLRUExpireCache(ownerMemoSize)
+}
+
+// Create implements EventHandler
+func (e *EnqueueRequestForTransitiveOwner) Create(evt event.CreateEvent, q workqueue.RateLimitingInterface) {
+	for _, req := range e.getOwnerRequests(evt.Object) {
+		q.Add(req)
+	}
+}
+
+// Update implements EventHandler
+func (e *EnqueueRequestForTransitiveOwner) Update(evt event.UpdateEvent, q workqueue.RateLimitingInterface) {
+	for _, req := range e.getOwnerRequests(evt.ObjectOld) {
+		q.Add(req)
+	}
+	for _, req := range e.getOwnerRequests(evt.ObjectNew) {
+		q.Add(req)
+	}
+}
+
+// Delete implements EventHandler
+func (e *EnqueueRequestForTransitiveOwner) Delete(evt event.DeleteEvent, q workqueue.RateLimitingInterface) {
+	for _, req := range e.getOwnerRequests(evt.Object) {
+		q.Add(req)
+	}
+}
+
+// Generic implements EventHandler
+func (e *EnqueueRequestForTransitiveOwner) Generic(evt event.GenericEvent, q workqueue.RateLimitingInterface) {
+	for _, req := range e.getOwnerRequests(evt.Object) {
+		q.Add(req)
+	}
+}
+
+// ownerRef is a reference to an owner, read from an owner reference or from annotations.
+type ownerRef struct {
+	gvk            schema.GroupVersionKind
+	namespacedName types.NamespacedName
+	uid            types.UID
+}
+
+// memoKey is the key of the requests reached from an owner with depth levels left to walk.
+type memoKey struct {
+	ref   ownerRef
+	depth int
+}
+
+// getOwnerRequests returns a reconcile request for each owner of type e.Type reached from object.
+func (e *EnqueueRequestForTransitiveOwner) getOwnerRequests(object client.Object) []reconcile.Request {
+	if object == nil {
+		return nil
+	}
+	maxDepth := e.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxOwnerDepth
+	}
+
+	var reqs []reconcile.Request
+	seen := map[reconcile.Request]bool{}
+	for _, ref := range e.ownerRefs(object) {
+		for _, req := range e.walk(ref, maxDepth-1, map[ownerRef]bool{}) {
+			if !seen[req] {
+				seen[req] = true
+				reqs = append(reqs, req)
+			}
+		}
+	}
+	return reqs
+}
+
+// walk returns the requests for the owners of type e.Type reached from ref, walking depth more levels of
+// owners. visiting are the owners being walked, to avoid cycles.
+func (e *EnqueueRequestForTransitiveOwner) walk(ref ownerRef, depth int, visiting map[ownerRef]bool) []reconcile.Request {
+	if ref.gvk.GroupKind() == e.Type {
+		return []reconcile.Request{{NamespacedName: ref.namespacedName}}
+	}
+	if depth <= 0 || visiting[ref] || e.reader == nil || e.scheme == nil {
+		return nil
+	}
+
+	key := memoKey{ref: ref, depth: depth}
+	if reqs, ok := e.memo.Get(key); ok {
+		return reqs.([]reconcile.Request)
+	}
+
+	owner, err := e.getOwner(ref)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			log.V(1).Info("Owner not found", "owner", ref.namespacedName, "kind", ref.gvk.String())
+		} else {
+			log.Error(err, "Unable to get owner", "owner", ref.namespacedName, "kind", ref.gvk.String())
+		}
+		// Errors are not remembered, the owner might be found on the next event.
+		return nil
+	}
+
+	visiting[ref] = true
+	defer delete(visiting, ref)
+	var reqs []reconcile.Request
+	seen := map[reconcile.Request]bool{}
+	for _, next := range e.ownerRefs(owner) {
+		for _, req := range e.walk(next, depth-1, visiting) {
+			if !seen[req] {
+				seen[req] = true
+				reqs = append(reqs, req)
+			}
+		}
+	}
+	e.memo.Add(key, reqs, ownerMemoTTL)
+	return reqs
+}
+
+// getOwner looks ref up in the cache. Owner references do not tell the scope of owners, so owners that are
+// not found in the namespace of their dependent are looked up as cluster-scoped owners.
+func (e *EnqueueRequestForTransitiveOwner) getOwner(ref ownerRef) (client.Object, error) {
+	obj, err := e.scheme.New(ref.gvk)
+	if err != nil {
+		return nil, err
+	}
+	owner, ok := obj.(client.Object)
+	if !ok {
+		return nil, fmt.Errorf("%T is not a client.Object", obj)
+	}
+
+	err = e.reader.Get(context.TODO(), ref.namespacedName, owner)
+	if apierrors.IsNotFound(err) && ref.namespacedName.Namespace != "" {
+		err = e.reader.Get(context.TODO(), types.NamespacedName{Name: ref.namespacedName.Name}, owner)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ref.uid != "" && owner.GetUID() != ref.uid {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Group: ref.gvk.Group, Resource: ref.gvk.Kind},
+			ref.namespacedName.Name)
+	}
+	return owner, nil
+}
+
+// ownerRefs returns the references to the owners of object that e follows.
+func (e *EnqueueRequestForTransitiveOwner) ownerRefs(object client.Object) []ownerRef {
+	var refs []ownerRef
+	for _, ref := range object.GetOwnerReferences() {
+		if e.IsController && (ref.Controller == nil || !*ref.Controller) {
+			continue
+		}
+		refs = append(refs, ownerRef{
+			gvk:            schema.FromAPIVersionAndKind(ref.APIVersion, ref.Kind),
+			namespacedName: types.NamespacedName{Namespace: object.GetNamespace(), Name: ref.Name},
+			uid:            ref.UID,
+		})
+	}
+	if !e.FollowAnnotations {
+		return refs
+	}
+
+	keys := e.Keys
+	if len(keys) == 0 {
+		keys = []AnnotationKeys{DefaultAnnotationKeys}
+	}
+	for _, k := range keys {
+		for _, owner := range k.getAnnotatedOwners(object.GetAnnotations()) {
+			if strings.TrimSpace(owner.NamespacedName) == "" {
+				continue
+			}
+			gk := schema.ParseGroupKind(owner.Type)
+			refs = append(refs, ownerRef{
+				gvk:            gk.WithVersion(e.ownerVersion(gk, owner.Version)),
+				namespacedName: parseNamespacedName(owner.NamespacedName),
+				uid:            owner.UID,
+			})
+		}
+	}
+	return refs
+}
+
+// ownerVersion returns the version to look an owner of type gk up with: the recorded one if any, or the
+// preferred version of gk in the scheme.
+func (e *EnqueueRequestForTransitiveOwner) ownerVersion(gk schema.GroupKind, recorded string) string {
+	if recorded != "" || e.scheme == nil {
+		return recorded
+	}
+	for _, gv := range e.scheme.PrioritizedVersionsForGroup(gk.Group) {
+		if e.scheme.Recognizes(gk.WithVersion(gv.Version)) {
+			return gv.Version
+		}
+	}
+	return ""
+}
@@ -0,0 +1,141 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllertest"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var _ = Describe("EnqueueRequestForTransitiveOwner", func() {
+	var (
+		q          workqueue.RateLimitingInterface
+		instance   *EnqueueRequestForTransitiveOwner
+		c          client.Client
+		deployment *appsv1.Deployment
+		replicaSet *appsv1.ReplicaSet
+		pod        *corev1.Pod
+		request    reconcile.Request
+	)
+	ownerReference := func(owner client.Object, gvk schema.GroupVersionKind, controller bool) metav1.OwnerReference {
+		return metav1.OwnerReference{APIVersion: gvk.GroupVersion().String(), Kind: gvk.Kind,
+			Name: owner.GetName(), UID: owner.GetUID(), Controller: &controller}
+	}
+	enqueued := func(obj client.Object) []interface{} {
+		instance.Create(event.CreateEvent{Object: obj}, q)
+		var items []interface{}
+		for q.Len() > 0 {
+			i, _ := q.Get()
+			items = append(items, i)
+			q.Done(i)
+		}
+		return items
+	}
+
+	BeforeEach(func() {
+		q = controllertest.Queue{Interface: workqueue.New()}
+		deployment = &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "app", UID: "deployment"}}
+		replicaSet = &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "app-1", UID: "replicaset",
+			OwnerReferences: []metav1.OwnerReference{
+				ownerReference(deployment, appsv1.SchemeGroupVersion.WithKind("Deployment"), true)}}}
+		pod = &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "app-1-x",
+			OwnerReferences: []metav1.OwnerReference{
+				ownerReference(replicaSet, appsv1.SchemeGroupVersion.WithKind("ReplicaSet"), true)}}}
+		request = reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "ns", Name: "app"}}
+
+		c = fake.NewClientBuilder().WithObjects(deployment, replicaSet).Build()
+		instance = &EnqueueRequestForTransitiveOwner{Type: schema.GroupKind{Group: "apps", Kind: "Deployment"}}
+		Expect(instance.InjectScheme(scheme.Scheme)).To(Succeed())
+		instance.setReader(c)
+	})
+
+	It("should enqueue an owner of an owner", func() {
+		Expect(enqueued(pod)).To(ConsistOf(request))
+	})
+	It("should enqueue a direct owner without looking it up", func() {
+		Expect(enqueued(replicaSet)).To(ConsistOf(request))
+		Expect(c.Delete(context.TODO(), deployment)).To(Succeed())
+		Expect(enqueued(replicaSet)).To(ConsistOf(request))
+	})
+	It("should enqueue the owners of the old and new objects on updates", func() {
+		orphan := pod.DeepCopy()
+		orphan.OwnerReferences = nil
+		instance.Update(event.UpdateEvent{ObjectOld: pod, ObjectNew: orphan}, q)
+		Expect(q.Len()).To(Equal(1))
+	})
+	It("should not walk further than MaxDepth", func() {
+		instance.MaxDepth = 1
+		Expect(enqueued(pod)).To(BeEmpty())
+		instance.MaxDepth = 2
+		Expect(enqueued(pod)).To(ConsistOf(request))
+	})
+	It("should only follow controller references when IsController is set", func() {
+		pod.OwnerReferences = []metav1.OwnerReference{
+			ownerReference(replicaSet, appsv1.SchemeGroupVersion.WithKind("ReplicaSet"), false)}
+		Expect(enqueued(pod)).To(ConsistOf(request))
+		instance.IsController = true
+		Expect(enqueued(pod)).To(BeEmpty())
+	})
+	It("should skip owners that do not exist or were recreated", func() {
+		Expect(c.Delete(context.TODO(), replicaSet)).To(Succeed())
+		Expect(enqueued(pod)).To(BeEmpty())
+
+		recreated := replicaSet.DeepCopy()
+		recreated.ResourceVersion = ""
+		recreated.UID = "recreated"
+		Expect(c.Create(context.TODO(), recreated)).To(Succeed())
+		Expect(enqueued(pod)).To(BeEmpty())
+	})
+	It("should remember the owners reached from an owner", func() {
+		Expect(enqueued(pod)).To(ConsistOf(request))
+		Expect(c.Delete(context.TODO(), replicaSet)).To(Succeed())
+		Expect(enqueued(pod)).To(ConsistOf(request))
+	})
+	It("should not loop on owner cycles", func() {
+		other := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "app-2", UID: "other",
+			OwnerReferences: []metav1.OwnerReference{
+				ownerReference(replicaSet, appsv1.SchemeGroupVersion.WithKind("ReplicaSet"), false)}}}
+		Expect(c.Create(context.TODO(), other)).To(Succeed())
+		replicaSet.OwnerReferences = []metav1.OwnerReference{
+			ownerReference(other, appsv1.SchemeGroupVersion.WithKind("ReplicaSet"), false)}
+		Expect(c.Update(context.TODO(), replicaSet)).To(Succeed())
+
+		instance.MaxDepth = 100
+		Expect(enqueued(pod)).To(BeEmpty())
+	})
+	It("should follow owner annotations when FollowAnnotations is set", func() {
+		replicaSet.SetGroupVersionKind(appsv1.SchemeGroupVersion.WithKind("ReplicaSet"))
+		annotated := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "other", Name: "config"}}
+		Expect(SetOwnerAnnotations(replicaSet, annotated)).To(Succeed())
+		Expect(enqueued(annotated)).To(BeEmpty())
+
+		instance.FollowAnnotations = true
+		Expect(enqueued(annotated)).To(ConsistOf(request))
+	})
+})
@@ -0,0 +1,113 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// AnnotatedOwnerIndexField is the name of the field index installed by IndexAnnotatedOwners, whose values
+// identify the owners recorded in the annotations of an object.
+const AnnotatedOwnerIndexField = "operator-sdk.annotatedOwner"
+
+// IndexAnnotatedOwners installs an index of the objects of the type of obj by the owners recorded in their
+// annotations with any of keys, defaulting to DefaultAnnotationKeys. It lets ListAnnotatedDependents
+// find the dependents of an owner without listing every object of their type. It is typically called
+// with the field indexer of a manager before it is started:
+//
+//	if err := handler.IndexAnnotatedOwners(ctx, mgr.GetFieldIndexer(), &rbacv1.ClusterRole{}); err != nil {
+//		return err
+//	}
+func IndexAnnotatedOwners(ctx context.Context, indexer client.FieldIndexer, obj client.Object,
+	keys ...AnnotationKeys) error {
+	if len(keys) == 0 {
+		keys = []AnnotationKeys{DefaultAnnotationKeys}
+	}
+	return indexer.IndexField(ctx, obj, AnnotatedOwnerIndexField, func(o client.Object) []string {
+		var values []string
+		seen := map[string]bool{}
+		for _, k := range keys {
+			for _, owner := range k.getAnnotatedOwners(o.GetAnnotations()) {
+				value := ownerIndexValue(owner)
+				if !seen[value] {
+					seen[value] = true
+					values = append(values, value)
+				}
+			}
+		}
+		return values
+	})
+}
+
+// ListAnnotatedDependents lists into list the dependents of owner recorded in their annotations, in all
+// namespaces unless a namespace is set in opts. The type of the dependents must have been indexed with
+// IndexAnnotatedOwners, and c must read from the cache of the indexer, as the client of a manager does.
+// Dependents recording owner with a different UID, which belong to a previous owner of the same name,
+// are not listed. The kind of owner is looked up in the scheme of c if it is not set. Owner types recorded
+// with a different case or version are matched, but not those recorded as resource or short names, which
+// the index cannot map to kinds.
+func ListAnnotatedDependents(ctx context.Context, c client.Client, owner client.Object, list client.ObjectList,
+	opts ...client.ListOption) error {
+	o, err := annotatedOwnerOf(owner, c, "ListAnnotatedDependents")
+	if err != nil {
+		return err
+	}
+
+	// Dependents recording the UID of owner are indexed under another value than those that do not.
+	anyUID := o
+	anyUID.UID = ""
+	values := []string{ownerIndexValue(anyUID)}
+	if o.UID != "" {
+		values = append(values, ownerIndexValue(o))
+	}
+
+	var dependents []runtime.Object
+	listed := map[client.ObjectKey]bool{}
+	for _, value := range values {
+		byOwner := client.MatchingFields{AnnotatedOwnerIndexField: value}
+		if err := c.List(ctx, list, append([]client.ListOption{byOwner}, opts...)...); err != nil {
+			return err
+		}
+		items, err := meta.ExtractList(list)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			key := client.ObjectKeyFromObject(item.(client.Object))
+			if !listed[key] {
+				listed[key] = true
+				dependents = append(dependents, item.DeepCopyObject())
+			}
+		}
+	}
+	return meta.SetList(list, dependents)
+}
+
+// ownerIndexValue returns the value identifying owner in the AnnotatedOwnerIndexField index. Owners whose UID
+// is recorded have values distinct from those whose UID is not. Types are normalized, so that owners whose
+// types are recorded with a different case, or version, share values.
+func ownerIndexValue(owner annotatedOwner) string {
+	nsn := parseNamespacedName(owner.NamespacedName)
+	value := fmt.Sprintf("%s/%s/%s", normalizedType(owner.Type), nsn.Namespace, nsn.Name)
+	if owner.UID != "" {
+		value += "/" + string(owner.UID)
+	}
+	return value
+}
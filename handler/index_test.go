@@ -0,0 +1,157 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// indexedClient is a fake client supporting field indexes, as the cache of a manager does.
+type indexedClient struct {
+	client.Client
+	indexes map[string]client.IndexerFunc
+}
+
+func (c *indexedClient) IndexField(_ context.Context, _ client.Object, field string, f client.IndexerFunc) error {
+	c.indexes[field] = f
+	return nil
+}
+
+func (c *indexedClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	if err := c.Client.List(ctx, list, opts...); err != nil {
+		return err
+	}
+	listOpts := &client.ListOptions{}
+	listOpts.ApplyOptions(opts)
+	if listOpts.FieldSelector == nil {
+		return nil
+	}
+	items, err := meta.ExtractList(list)
+	if err != nil {
+		return err
+	}
+	var matching []runtime.Object
+	for _, item := range items {
+		matches := true
+		for _, req := range listOpts.FieldSelector.Requirements() {
+			found := false
+			for _, value := range c.indexes[req.Field](item.(client.Object)) {
+				found = found || value == req.Value
+			}
+			matches = matches && found
+		}
+		if matches {
+			matching = append(matching, item)
+		}
+	}
+	return meta.SetList(list, matching)
+}
+
+var _ = Describe("Annotated owner index", func() {
+	var (
+		ctx   context.Context
+		c     *indexedClient
+		owner *corev1.Pod
+		other *corev1.Pod
+	)
+	newRole := func(name string, owners ...client.Object) *rbacv1.ClusterRole {
+		role := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		for _, o := range owners {
+			Expect(AddOwnerAnnotation(o, role)).To(Succeed())
+		}
+		return role
+	}
+	names := func(list *rbacv1.ClusterRoleList) []string {
+		var names []string
+		for _, item := range list.Items {
+			names = append(names, item.Name)
+		}
+		return names
+	}
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		owner = &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "owner", UID: "uid-1"}}
+		owner.SetGroupVersionKind(schema.GroupVersionKind{Version: "v1", Kind: "Pod"})
+		other = owner.DeepCopy()
+		other.Name = "other"
+	})
+
+	It("should list the dependents of an owner", func() {
+		withoutUID := owner.DeepCopy()
+		withoutUID.UID = ""
+		legacy := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "legacy"}}
+		Expect(SetOwnerAnnotations(withoutUID, legacy)).To(Succeed())
+		stale := owner.DeepCopy()
+		stale.UID = "uid-0"
+
+		c = &indexedClient{
+			Client: fake.NewClientBuilder().WithObjects(
+				newRole("owned", owner), newRole("shared", other, owner), newRole("unrelated", other),
+				newRole("stale", stale), legacy,
+			).Build(),
+			indexes: map[string]client.IndexerFunc{},
+		}
+		Expect(IndexAnnotatedOwners(ctx, c, &rbacv1.ClusterRole{})).To(Succeed())
+
+		// Typed objects read with a client have no kind.
+		owner.SetGroupVersionKind(schema.GroupVersionKind{})
+		list := &rbacv1.ClusterRoleList{}
+		Expect(ListAnnotatedDependents(ctx, c, owner, list)).To(Succeed())
+		Expect(names(list)).To(ConsistOf("legacy", "owned", "shared"))
+	})
+	It("should list the dependents whose owner type is recorded with another case or version", func() {
+		recased := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "recased", Annotations: map[string]string{
+			OwnersAnnotation: `[{"type":"POD","namespacedName":"ns/owner","uid":"uid-1","version":"v2"}]`,
+		}}}
+		c = &indexedClient{
+			Client:  fake.NewClientBuilder().WithObjects(recased, newRole("owned", owner)).Build(),
+			indexes: map[string]client.IndexerFunc{},
+		}
+		Expect(IndexAnnotatedOwners(ctx, c, &rbacv1.ClusterRole{})).To(Succeed())
+
+		list := &rbacv1.ClusterRoleList{}
+		Expect(ListAnnotatedDependents(ctx, c, owner, list)).To(Succeed())
+		Expect(names(list)).To(ConsistOf("owned", "recased"))
+	})
+	It("should index the owners recorded with any of the keys", func() {
+		keys := AnnotationKeysWithPrefix("example.com")
+		prefixed := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "prefixed"}}
+		Expect(keys.AddOwnerAnnotation(owner, prefixed)).To(Succeed())
+		Expect(SetOwnerAnnotations(owner, prefixed)).To(Succeed())
+
+		c = &indexedClient{
+			Client:  fake.NewClientBuilder().WithObjects(prefixed, newRole("default", owner)).Build(),
+			indexes: map[string]client.IndexerFunc{},
+		}
+		Expect(IndexAnnotatedOwners(ctx, c, &rbacv1.ClusterRole{}, keys)).To(Succeed())
+		Expect(c.indexes[AnnotatedOwnerIndexField](prefixed)).To(Equal([]string{"pod/ns/owner/uid-1"}))
+
+		list := &rbacv1.ClusterRoleList{}
+		Expect(ListAnnotatedDependents(ctx, c, owner, list)).To(Succeed())
+		Expect(names(list)).To(ConsistOf("prefixed"))
+	})
+})
@@ -69,7 +69,7 @@ var _ = Describe("InstrumentedEnqueueRequestForObject", func() {
 			}))
 
 			// verify metrics
-			gauges, err := metrics.Registry.Gather()
+			gauges, err := gatherResourceCreatedAt()
 			Expect(err).NotTo(HaveOccurred())
 			Expect(len(gauges)).To(Equal(1))
 			assertMetrics(gauges[0], 1, []*corev1.Pod{pod})
@@ -104,7 +104,7 @@ var _ = Describe("InstrumentedEnqueueRequestForObject", func() {
 				}))
 
 				// verify metrics
-				gauges, err := metrics.Registry.Gather()
+				gauges, err := gatherResourceCreatedAt()
 				Expect(err).NotTo(HaveOccurred())
 				Expect(len(gauges)).To(Equal(0))
 			})
@@ -129,7 +129,7 @@ var _ = Describe("InstrumentedEnqueueRequestForObject", func() {
 				}))
 
 				// verify metrics
-				gauges, err := metrics.Registry.Gather()
+				gauges, err := gatherResourceCreatedAt()
 				Expect(err).NotTo(HaveOccurred())
 				Expect(len(gauges)).To(Equal(0))
 			})
@@ -171,7 +171,7 @@ var _ = Describe("InstrumentedEnqueueRequestForObject", func() {
 			}))
 
 			// verify metrics
-			gauges, err := metrics.Registry.Gather()
+			gauges, err := gatherResourceCreatedAt()
 			Expect(err).NotTo(HaveOccurred())
 			Expect(len(gauges)).To(Equal(1))
 			assertMetrics(gauges[0], 2, []*corev1.Pod{newpod, pod})
@@ -192,6 +192,22 @@ var _ = Describe("InstrumentedEnqueueRequestForObject", func() {
 	})
 })
 
+// gatherResourceCreatedAt gathers the metrics of InstrumentedEnqueueRequestForObject, ignoring those
+// registered by controller-runtime.
+func gatherResourceCreatedAt() ([]*dto.MetricFamily, error) {
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		return nil, err
+	}
+	var gauges []*dto.MetricFamily
+	for _, f := range families {
+		if f.GetName() == "resource_created_at_seconds" {
+			gauges = append(gauges, f)
+		}
+	}
+	return gauges, nil
+}
+
 func assertMetrics(gauge *dto.MetricFamily, count int, pods []*corev1.Pod) {
 	// need variables to compare the pointers
 	name := "name"
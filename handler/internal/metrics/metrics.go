@@ -26,8 +26,17 @@ var ResourceCreatedAt = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 	Help: "Timestamp at which a resource was created",
 }, []string{"name", "namespace", "group", "version", "kind"})
 
+// MalformedOwnerAnnotations creates new prometheus metrics for the malformed
+// annotations recording owners found on dependent resources, with
+// information {"annotation"}
+var MalformedOwnerAnnotations = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "malformed_owner_annotations_total",
+	Help: "Number of malformed owner annotations found on dependent resources",
+}, []string{"annotation"})
+
 func init() {
 	metrics.Registry.MustRegister(
 		ResourceCreatedAt,
+		MalformedOwnerAnnotations,
 	)
 }
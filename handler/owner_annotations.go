@@ -0,0 +1,257 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// OwnersAnnotation is an annotation whose value is a JSON list of the owners to reconcile when a resource
+// containing this annotation changes. Each owner has a "type" and a "namespacedName", whose values have the
+// same form as those of TypeAnnotation and NamespacedNameAnnotation:
+//
+//	annotations:
+//		operator-sdk/primary-resources: '[{"type":"ReplicaSet.apps","namespacedName":"my-namespace/my-replicaset"}]'
+//
+// It is managed by AddOwnerAnnotation and RemoveOwnerAnnotation, and lets a dependent shared by several
+// owners trigger the reconciliation of each of them.
+const OwnersAnnotation = "operator-sdk/primary-resources"
+
+// UIDAnnotation is an annotation whose value is the UID of the owner identified by NamespacedNameAnnotation
+// and TypeAnnotation. It is set by SetOwnerAnnotations when the owner has a UID, and lets
+// EnqueueRequestForAnnotation tell an owner apart from another one recreated with the same name. Owners
+// listed in OwnersAnnotation record their UID in a "uid" field instead.
+const UIDAnnotation = "operator-sdk/primary-resource-uid"
+
+// VersionAnnotation is an annotation whose value is the version of the owner identified by
+// NamespacedNameAnnotation and TypeAnnotation that created the resource containing this annotation. It is
+// set by SetOwnerAnnotations when the version of the owner is known. Owners listed in OwnersAnnotation
+// record their version in a "version" field instead.
+const VersionAnnotation = "operator-sdk/primary-resource-version"
+
+// AnnotationKeys are the keys of the annotations recording the owners of a dependent. Operators that
+// watch the same dependents, or that migrate from another framework, can use their own keys to avoid
+// colliding with each other.
+type AnnotationKeys struct {
+	// NamespacedName and Type record a single owner, as NamespacedNameAnnotation and TypeAnnotation do.
+	NamespacedName string
+	Type           string
+	// Owners records a list of owners, as OwnersAnnotation does.
+	Owners string
+	// UID records the UID of a single owner, as UIDAnnotation does. UIDs of single owners are not
+	// recorded if it is empty.
+	UID string
+	// Version records the version of a single owner, as VersionAnnotation does. Versions of single owners
+	// are not recorded if it is empty.
+	Version string
+}
+
+// DefaultAnnotationKeys are the keys used by SetOwnerAnnotations, AddOwnerAnnotation,
+// RemoveOwnerAnnotation, and EnqueueRequestForAnnotation when no keys are set.
+var DefaultAnnotationKeys = AnnotationKeys{
+	NamespacedName: NamespacedNameAnnotation,
+	Type:           TypeAnnotation,
+	Owners:         OwnersAnnotation,
+	UID:            UIDAnnotation,
+	Version:        VersionAnnotation,
+}
+
+// AnnotationKeysWithPrefix returns AnnotationKeys like DefaultAnnotationKeys, with prefix instead of
+// "operator-sdk", e.g. "example.com/primary-resource" for the prefix "example.com".
+func AnnotationKeysWithPrefix(prefix string) AnnotationKeys {
+	return AnnotationKeys{
+		NamespacedName: prefix + "/primary-resource",
+		Type:           prefix + "/primary-resource-type",
+		Owners:         prefix + "/primary-resources",
+		UID:            prefix + "/primary-resource-uid",
+		Version:        prefix + "/primary-resource-version",
+	}
+}
+
+// annotatedOwner is an owner recorded in the annotations of a dependent.
+type annotatedOwner struct {
+	Type           string    `json:"type"`
+	NamespacedName string    `json:"namespacedName"`
+	UID            types.UID `json:"uid,omitempty"`
+	Version        string    `json:"version,omitempty"`
+
+	// key is the annotation the owner was read from, if any.
+	key string
+}
+
+// is returns true if o and other identify the same owner, regardless of their UIDs.
+func (o annotatedOwner) is(other annotatedOwner) bool {
+	return o.Type == other.Type && o.NamespacedName == other.NamespacedName
+}
+
+// newAnnotatedOwner returns the annotatedOwner describing owner. caller names the exported function
+// in error messages.
+func newAnnotatedOwner(owner client.Object, caller string) (annotatedOwner, error) {
+	if owner.GetName() == "" {
+		return annotatedOwner{}, fmt.Errorf("%T does not have a name, cannot call %s", owner, caller)
+	}
+
+	ownerGK := owner.GetObjectKind().GroupVersionKind().GroupKind()
+
+	if ownerGK.Kind == "" {
+		return annotatedOwner{}, fmt.Errorf("Owner %s Kind not found, cannot call %s", owner.GetName(), caller)
+	}
+
+	return annotatedOwner{
+		Type:           ownerGK.String(),
+		NamespacedName: fmt.Sprintf("%s/%s", owner.GetNamespace(), owner.GetName()),
+		UID:            owner.GetUID(),
+		Version:        owner.GetObjectKind().GroupVersionKind().Version,
+	}, nil
+}
+
+// getAnnotatedOwners returns the owners recorded in annotations, both in the single-owner format of
+// the Type and NamespacedName keys, and in the list format of the Owners key. Malformed annotations are
+// reported and ignored.
+func (k AnnotationKeys) getAnnotatedOwners(annotations map[string]string) []annotatedOwner {
+	owners, errs := k.parseAnnotatedOwners(annotations)
+	for _, err := range errs {
+		reportMalformedAnnotation(err)
+	}
+	return owners
+}
+
+// parseAnnotatedOwners is like getAnnotatedOwners, but returns the errors of malformed annotations.
+func (k AnnotationKeys) parseAnnotatedOwners(annotations map[string]string) ([]annotatedOwner, []error) {
+	var owners []annotatedOwner
+	var errs []error
+	typeString, hasType := annotations[k.Type]
+	namespacedNameString, hasNamespacedName := annotations[k.NamespacedName]
+	switch {
+	case hasType && !hasNamespacedName:
+		errs = append(errs, &MalformedAnnotationError{Key: k.Type, Value: typeString,
+			Reason: fmt.Sprintf("annotation %s is missing", k.NamespacedName)})
+	case hasType:
+		owner := annotatedOwner{Type: typeString, NamespacedName: namespacedNameString, key: k.NamespacedName}
+		if k.UID != "" {
+			owner.UID = types.UID(annotations[k.UID])
+		}
+		if k.Version != "" {
+			owner.Version = annotations[k.Version]
+		}
+		owners = append(owners, owner)
+	case hasNamespacedName:
+		errs = append(errs, &MalformedAnnotationError{Key: k.NamespacedName, Value: namespacedNameString,
+			Reason: fmt.Sprintf("annotation %s is missing", k.Type)})
+	}
+	if list, ok := annotations[k.Owners]; ok {
+		var listed []annotatedOwner
+		if err := json.Unmarshal([]byte(list), &listed); err != nil {
+			errs = append(errs, &MalformedAnnotationError{Key: k.Owners, Value: list, Reason: err.Error()})
+		}
+		for _, owner := range listed {
+			owner.key = k.Owners
+			owners = append(owners, owner)
+		}
+	}
+	return owners, errs
+}
+
+// setAnnotatedOwners records owners in the list format of the Owners key, removing the single-owner
+// annotations. The Owners annotation is removed if owners is empty.
+func (k AnnotationKeys) setAnnotatedOwners(object client.Object, owners []annotatedOwner) error {
+	annotations := object.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	delete(annotations, k.Type)
+	delete(annotations, k.NamespacedName)
+	if k.UID != "" {
+		delete(annotations, k.UID)
+	}
+	if k.Version != "" {
+		delete(annotations, k.Version)
+	}
+
+	if len(owners) == 0 {
+		delete(annotations, k.Owners)
+	} else {
+		list, err := json.Marshal(owners)
+		if err != nil {
+			return err
+		}
+		annotations[k.Owners] = string(list)
+	}
+	object.SetAnnotations(annotations)
+	return nil
+}
+
+// AddOwnerAnnotation adds owner to the owners recorded in the annotations of object, so that a change to
+// object triggers the reconciliation of owner in addition to its other owners. The owners are recorded in
+// OwnersAnnotation; an owner recorded by SetOwnerAnnotations is moved there. Adding an owner that is
+// already recorded does nothing, unless its UID or version changed, in which case they are updated.
+func AddOwnerAnnotation(owner, object client.Object) error {
+	return DefaultAnnotationKeys.AddOwnerAnnotation(owner, object)
+}
+
+// AddOwnerAnnotation is like the AddOwnerAnnotation function, but uses the keys of k.
+func (k AnnotationKeys) AddOwnerAnnotation(owner, object client.Object) error {
+	added, err := newAnnotatedOwner(owner, "AddOwnerAnnotation")
+	if err != nil {
+		return err
+	}
+
+	owners := k.getAnnotatedOwners(object.GetAnnotations())
+	for i, o := range owners {
+		if o.is(added) {
+			if o.UID == added.UID && o.Version == added.Version {
+				return nil
+			}
+			owners[i].UID, owners[i].Version = added.UID, added.Version
+			return k.setAnnotatedOwners(object, owners)
+		}
+	}
+	return k.setAnnotatedOwners(object, append(owners, added))
+}
+
+// RemoveOwnerAnnotation removes owner from the owners recorded in the annotations of object, whether it
+// was recorded by SetOwnerAnnotations or AddOwnerAnnotation, and whatever its recorded UID. Removing an
+// owner that is not recorded does nothing.
+func RemoveOwnerAnnotation(owner, object client.Object) error {
+	return DefaultAnnotationKeys.RemoveOwnerAnnotation(owner, object)
+}
+
+// RemoveOwnerAnnotation is like the RemoveOwnerAnnotation function, but uses the keys of k.
+func (k AnnotationKeys) RemoveOwnerAnnotation(owner, object client.Object) error {
+	removed, err := newAnnotatedOwner(owner, "RemoveOwnerAnnotation")
+	if err != nil {
+		return err
+	}
+	return k.removeAnnotatedOwner(object, removed)
+}
+
+// removeAnnotatedOwner removes removed from the owners recorded in the annotations of object.
+func (k AnnotationKeys) removeAnnotatedOwner(object client.Object, removed annotatedOwner) error {
+	owners := k.getAnnotatedOwners(object.GetAnnotations())
+	kept := make([]annotatedOwner, 0, len(owners))
+	for _, o := range owners {
+		if !o.is(removed) {
+			kept = append(kept, o)
+		}
+	}
+	if len(kept) == len(owners) {
+		return nil
+	}
+	return k.setAnnotatedOwners(object, kept)
+}
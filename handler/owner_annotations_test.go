@@ -0,0 +1,167 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllertest"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var _ = Describe("Owner annotations", func() {
+	var (
+		q          workqueue.RateLimitingInterface
+		instance   EnqueueRequestForAnnotation
+		role       *rbacv1.ClusterRole
+		ownerA     *corev1.Pod
+		ownerB     *corev1.Pod
+		drainQueue func() []interface{}
+	)
+	newOwner := func(name string) *corev1.Pod {
+		owner := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: name}}
+		owner.SetGroupVersionKind(schema.GroupVersionKind{Kind: "Pod"})
+		return owner
+	}
+	request := func(name string) reconcile.Request {
+		return reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "ns", Name: name}}
+	}
+
+	BeforeEach(func() {
+		q = controllertest.Queue{Interface: workqueue.New()}
+		instance = EnqueueRequestForAnnotation{Type: schema.GroupKind{Kind: "Pod"}}
+		role = &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "shared"}}
+		ownerA = newOwner("a")
+		ownerB = newOwner("b")
+		drainQueue = func() []interface{} {
+			var items []interface{}
+			for q.Len() > 0 {
+				i, _ := q.Get()
+				items = append(items, i)
+			}
+			return items
+		}
+	})
+
+	Describe("AddOwnerAnnotation", func() {
+		It("should enqueue a Request for every owner", func() {
+			Expect(AddOwnerAnnotation(ownerA, role)).To(Succeed())
+			Expect(AddOwnerAnnotation(ownerB, role)).To(Succeed())
+			Expect(role.Annotations).To(HaveKeyWithValue(OwnersAnnotation,
+				`[{"type":"Pod","namespacedName":"ns/a"},{"type":"Pod","namespacedName":"ns/b"}]`))
+
+			instance.Create(event.CreateEvent{Object: role}, q)
+			Expect(drainQueue()).To(ConsistOf(request("a"), request("b")))
+		})
+		It("should not add an owner twice", func() {
+			Expect(AddOwnerAnnotation(ownerA, role)).To(Succeed())
+			Expect(AddOwnerAnnotation(ownerA, role)).To(Succeed())
+			Expect(DefaultAnnotationKeys.getAnnotatedOwners(role.Annotations)).To(HaveLen(1))
+		})
+		It("should move an owner set by SetOwnerAnnotations to the list", func() {
+			Expect(SetOwnerAnnotations(ownerA, role)).To(Succeed())
+			Expect(AddOwnerAnnotation(ownerB, role)).To(Succeed())
+			Expect(role.Annotations).NotTo(HaveKey(TypeAnnotation))
+			Expect(role.Annotations).NotTo(HaveKey(NamespacedNameAnnotation))
+
+			instance.Create(event.CreateEvent{Object: role}, q)
+			Expect(drainQueue()).To(ConsistOf(request("a"), request("b")))
+		})
+		It("should only enqueue owners of the watched type", func() {
+			other := newOwner("c")
+			other.SetGroupVersionKind(schema.GroupVersionKind{Group: "apps", Kind: "ReplicaSet"})
+			Expect(AddOwnerAnnotation(ownerA, role)).To(Succeed())
+			Expect(AddOwnerAnnotation(other, role)).To(Succeed())
+
+			instance.Create(event.CreateEvent{Object: role}, q)
+			Expect(drainQueue()).To(ConsistOf(request("a")))
+		})
+	})
+
+	Describe("RemoveOwnerAnnotation", func() {
+		It("should remove an owner from the list", func() {
+			Expect(AddOwnerAnnotation(ownerA, role)).To(Succeed())
+			Expect(AddOwnerAnnotation(ownerB, role)).To(Succeed())
+			Expect(RemoveOwnerAnnotation(ownerA, role)).To(Succeed())
+
+			instance.Create(event.CreateEvent{Object: role}, q)
+			Expect(drainQueue()).To(ConsistOf(request("b")))
+
+			Expect(RemoveOwnerAnnotation(ownerB, role)).To(Succeed())
+			Expect(role.Annotations).To(BeEmpty())
+		})
+		It("should remove an owner set by SetOwnerAnnotations", func() {
+			Expect(SetOwnerAnnotations(ownerA, role)).To(Succeed())
+			Expect(RemoveOwnerAnnotation(ownerA, role)).To(Succeed())
+			Expect(role.Annotations).To(BeEmpty())
+		})
+		It("should leave the annotations alone when the owner is not recorded", func() {
+			Expect(SetOwnerAnnotations(ownerA, role)).To(Succeed())
+			Expect(RemoveOwnerAnnotation(ownerB, role)).To(Succeed())
+			Expect(role.Annotations).To(HaveKeyWithValue(NamespacedNameAnnotation, "ns/a"))
+		})
+	})
+
+	Describe("AnnotationKeys", func() {
+		var keys AnnotationKeys
+		BeforeEach(func() {
+			keys = AnnotationKeysWithPrefix("example.com")
+		})
+
+		It("should record owners with its own keys", func() {
+			Expect(keys.SetOwnerAnnotations(ownerA, role)).To(Succeed())
+			Expect(role.Annotations).To(Equal(map[string]string{
+				"example.com/primary-resource":      "ns/a",
+				"example.com/primary-resource-type": "Pod",
+			}))
+
+			Expect(keys.AddOwnerAnnotation(ownerB, role)).To(Succeed())
+			Expect(role.Annotations).To(Equal(map[string]string{
+				"example.com/primary-resources": `[{"type":"Pod","namespacedName":"ns/a"},{"type":"Pod","namespacedName":"ns/b"}]`,
+			}))
+
+			Expect(keys.RemoveOwnerAnnotation(ownerA, role)).To(Succeed())
+			Expect(keys.RemoveOwnerAnnotation(ownerB, role)).To(Succeed())
+			Expect(role.Annotations).To(BeEmpty())
+		})
+		It("should not collide with the default keys", func() {
+			Expect(keys.SetOwnerAnnotations(ownerA, role)).To(Succeed())
+			Expect(SetOwnerAnnotations(ownerB, role)).To(Succeed())
+
+			instance.Create(event.CreateEvent{Object: role}, q)
+			Expect(drainQueue()).To(ConsistOf(request("b")))
+
+			instance.Keys = []AnnotationKeys{keys}
+			instance.Create(event.CreateEvent{Object: role}, q)
+			Expect(drainQueue()).To(ConsistOf(request("a")))
+		})
+		It("should enqueue the owners recorded with any of several keys once", func() {
+			Expect(keys.AddOwnerAnnotation(ownerA, role)).To(Succeed())
+			Expect(SetOwnerAnnotations(ownerA, role)).To(Succeed())
+			Expect(AddOwnerAnnotation(ownerB, role)).To(Succeed())
+
+			instance.Keys = []AnnotationKeys{DefaultAnnotationKeys, keys}
+			instance.Create(event.CreateEvent{Object: role}, q)
+			Expect(drainQueue()).To(ConsistOf(request("a"), request("b")))
+		})
+	})
+})
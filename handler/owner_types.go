@@ -0,0 +1,138 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilcache "k8s.io/apimachinery/pkg/util/cache"
+	"sigs.k8s.io/controller-runtime/pkg/runtime/inject"
+)
+
+var (
+	_ inject.Mapper = &EnqueueRequestForAnnotation{}
+	_ inject.Mapper = &EnqueueRequestForLabel{}
+)
+
+// InjectMapper is called by Controller.Watch to provide the RESTMapper used to match the types of owners.
+func (e *EnqueueRequestForAnnotation) InjectMapper(m meta.RESTMapper) error {
+	e.mapper = newTypeMapper(m)
+	return nil
+}
+
+// InjectMapper is called by Controller.Watch to provide the RESTMapper used to match the types of owners.
+func (e *EnqueueRequestForLabel) InjectMapper(m meta.RESTMapper) error {
+	e.mapper = newTypeMapper(m)
+	return nil
+}
+
+// types returns the types of the owners enqueued by e.
+func (e *EnqueueRequestForAnnotation) types() []schema.GroupKind {
+	if e.Type.Kind == "" {
+		return e.Types
+	}
+	return append([]schema.GroupKind{e.Type}, e.Types...)
+}
+
+// matchType returns the type of e matching the recorded type of owner, if any.
+func (e *EnqueueRequestForAnnotation) matchType(owner annotatedOwner) (schema.GroupKind, bool) {
+	return matchOwnerType(e.types(), e.mapper, owner)
+}
+
+// matchOwnerType returns the type of types matching the recorded type of owner, if any. Recorded types match
+// a type if they are equal to it regardless of case or, if mapper is not nil, if they map to it.
+func matchOwnerType(types []schema.GroupKind, mapper *typeMapper, owner annotatedOwner) (schema.GroupKind, bool) {
+	recorded := schema.ParseGroupKind(owner.Type)
+	for _, t := range types {
+		if owner.Type == t.String() ||
+			(strings.EqualFold(recorded.Kind, t.Kind) && strings.EqualFold(recorded.Group, t.Group)) {
+			return t, true
+		}
+	}
+
+	if mapper == nil {
+		return schema.GroupKind{}, false
+	}
+	if mapped := mapper.kindFor(annotatedType{Type: owner.Type, Version: owner.Version}); mapped != nil {
+		for _, t := range types {
+			if *mapped == t {
+				return t, true
+			}
+		}
+	}
+	return schema.GroupKind{}, false
+}
+
+// normalizedType returns the recorded type ownerType in lower case, so that types matching regardless of
+// case have the same normalized type. Resource and short names are not mapped to kinds.
+func normalizedType(ownerType string) string {
+	return strings.ToLower(schema.ParseGroupKind(ownerType).String())
+}
+
+// annotatedType is the type and version of an owner recorded in annotations.
+type annotatedType struct {
+	Type    string
+	Version string
+}
+
+const (
+	// typeMemoSize is the number of recorded types whose mapping is remembered by typeMapper. Types are
+	// read from annotations anyone can write, so the memo is bounded.
+	typeMemoSize = 1024
+	// typeMemoTTL is how long a recorded type mapped to a kind is remembered.
+	typeMemoTTL = 10 * time.Minute
+	// typeMissTTL is how long a recorded type that could not be mapped is remembered, so that types
+	// installed later, e.g. by a CRD, and transient discovery errors are eventually retried.
+	typeMissTTL = 30 * time.Second
+)
+
+// typeMapper maps recorded types to kinds with a RESTMapper, remembering the kinds it mapped, or failed to
+// map, for a while, so that events are not slowed down by the discovery done by the RESTMapper on unknown
+// types.
+type typeMapper struct {
+	mapper meta.RESTMapper
+	kinds  *utilcache.LRUExpireCache
+}
+
+func newTypeMapper(m meta.RESTMapper) *typeMapper {
+	return &typeMapper{mapper: m, kinds: utilcache.NewLRUExpireCache(typeMemoSize)}
+}
+
+// kindFor returns the kind t is a kind, resource, or short name of, or nil if it is none.
+func (m *typeMapper) kindFor(t annotatedType) *schema.GroupKind {
+	if kind, ok := m.kinds.Get(t); ok {
+		return kind.(*schema.GroupKind)
+	}
+
+	// Concurrent events may map the same type more than once, which is cheaper than holding a lock while
+	// the RESTMapper does discovery.
+	recorded := schema.ParseGroupKind(t.Type)
+	gvk, err := m.mapper.KindFor(schema.GroupVersionResource{
+		Group:    recorded.Group,
+		Version:  t.Version,
+		Resource: strings.ToLower(recorded.Kind),
+	})
+	if err != nil {
+		log.V(1).Info("Unable to map owner type", "type", t.Type, "version", t.Version, "error", err.Error())
+		m.kinds.Add(t, (*schema.GroupKind)(nil), typeMissTTL)
+		return nil
+	}
+	gk := gvk.GroupKind()
+	m.kinds.Add(t, &gk, typeMemoTTL)
+	return &gk
+}
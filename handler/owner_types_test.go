@@ -0,0 +1,153 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilcache "k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/apimachinery/pkg/util/clock"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/restmapper"
+	kubetesting "k8s.io/client-go/testing"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllertest"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var _ = Describe("Owner types", func() {
+	var (
+		q        workqueue.RateLimitingInterface
+		instance *EnqueueRequestForAnnotation
+		dep      *corev1.ConfigMap
+	)
+	enqueued := func() []interface{} {
+		instance.Create(event.CreateEvent{Object: dep}, q)
+		var items []interface{}
+		for q.Len() > 0 {
+			i, _ := q.Get()
+			items = append(items, i)
+			q.Done(i)
+		}
+		return items
+	}
+	request := func(name string) reconcile.Request {
+		return reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "ns", Name: name}}
+	}
+	annotateWithType := func(ownerType string) {
+		dep.Annotations = map[string]string{TypeAnnotation: ownerType, NamespacedNameAnnotation: "ns/owner"}
+	}
+
+	BeforeEach(func() {
+		q = controllertest.Queue{Interface: workqueue.New()}
+		instance = &EnqueueRequestForAnnotation{Type: schema.GroupKind{Group: "apps", Kind: "ReplicaSet"}}
+		dep = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "dependent"}}
+	})
+
+	It("should record the version of the owner", func() {
+		owner := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "owner"}}
+		owner.SetGroupVersionKind(appsv1.SchemeGroupVersion.WithKind("ReplicaSet"))
+		Expect(SetOwnerAnnotations(owner, dep)).To(Succeed())
+		Expect(dep.Annotations).To(HaveKeyWithValue(VersionAnnotation, "v1"))
+
+		refs, err := ParseOwnerAnnotations(dep)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(refs).To(HaveLen(1))
+		Expect(refs[0].Version).To(Equal("v1"))
+
+		owner.SetGroupVersionKind(schema.GroupVersionKind{Group: "apps", Kind: "ReplicaSet"})
+		Expect(SetOwnerAnnotations(owner, dep)).To(Succeed())
+		Expect(dep.Annotations).NotTo(HaveKey(VersionAnnotation))
+	})
+	It("should match types regardless of case", func() {
+		annotateWithType("replicaset.APPS")
+		Expect(enqueued()).To(ConsistOf(request("owner")))
+	})
+	It("should match any of the types", func() {
+		instance.Types = []schema.GroupKind{{Kind: "Pod"}}
+		dep.Annotations = map[string]string{
+			OwnersAnnotation: `[{"type":"ReplicaSet.apps","namespacedName":"ns/a"},` +
+				`{"type":"Pod","namespacedName":"ns/b"},{"type":"Node","namespacedName":"/c"}]`,
+		}
+		Expect(enqueued()).To(ConsistOf(request("a"), request("b")))
+	})
+
+	Context("with a RESTMapper", func() {
+		BeforeEach(func() {
+			mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{appsv1.SchemeGroupVersion})
+			mapper.Add(appsv1.SchemeGroupVersion.WithKind("ReplicaSet"), meta.RESTScopeNamespace)
+			discovery := &fakediscovery.FakeDiscovery{Fake: &kubetesting.Fake{Resources: []*metav1.APIResourceList{{
+				GroupVersion: appsv1.SchemeGroupVersion.String(),
+				APIResources: []metav1.APIResource{{Name: "replicasets", Kind: "ReplicaSet", ShortNames: []string{"rs"}}},
+			}}}}
+			Expect(instance.InjectMapper(restmapper.NewShortcutExpander(mapper, discovery))).To(Succeed())
+		})
+
+		It("should match resource names and short names", func() {
+			for _, ownerType := range []string{"replicasets.apps", "rs.apps", "rs"} {
+				annotateWithType(ownerType)
+				Expect(enqueued()).To(ConsistOf(request("owner")), ownerType)
+			}
+		})
+		It("should match resource names of the recorded version", func() {
+			dep.Annotations = map[string]string{
+				OwnersAnnotation: `[{"type":"replicasets.apps","namespacedName":"ns/owner","version":"v1"}]`,
+			}
+			Expect(enqueued()).To(ConsistOf(request("owner")))
+
+			dep.Annotations = map[string]string{
+				OwnersAnnotation: `[{"type":"replicasets.apps","namespacedName":"ns/owner","version":"v1beta1"}]`,
+			}
+			Expect(enqueued()).To(BeEmpty())
+		})
+		It("should not match other types", func() {
+			annotateWithType("deployments.apps")
+			Expect(enqueued()).To(BeEmpty())
+		})
+	})
+
+	Describe("typeMapper", func() {
+		It("should retry types it failed to map after a while", func() {
+			clk := clock.NewFakeClock(time.Now())
+			mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{appsv1.SchemeGroupVersion})
+			m := &typeMapper{mapper: mapper, kinds: utilcache.NewLRUExpireCacheWithClock(typeMemoSize, clk)}
+			t := annotatedType{Type: "replicasets.apps"}
+			Expect(m.kindFor(t)).To(BeNil())
+
+			// e.g. a CRD installed after the first event
+			mapper.Add(appsv1.SchemeGroupVersion.WithKind("ReplicaSet"), meta.RESTScopeNamespace)
+			Expect(m.kindFor(t)).To(BeNil())
+			clk.Step(typeMissTTL + time.Second)
+			Expect(m.kindFor(t)).To(Equal(&schema.GroupKind{Group: "apps", Kind: "ReplicaSet"}))
+		})
+		It("should bound the types it remembers", func() {
+			m := newTypeMapper(meta.NewDefaultRESTMapper(nil))
+			for i := 0; i < 2*typeMemoSize; i++ {
+				m.kindFor(annotatedType{Type: fmt.Sprintf("kind%d.example.com", i)})
+			}
+			Expect(len(m.kinds.Keys())).To(Equal(typeMemoSize))
+		})
+	})
+})
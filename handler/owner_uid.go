@@ -0,0 +1,119 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"context"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/runtime/inject"
+)
+
+// OwnerUIDCheck is what EnqueueRequestForAnnotation does with an owner whose UID recorded in the
+// annotations of a dependent differs from the UID of the current owner.
+type OwnerUIDCheck int
+
+const (
+	// IgnoreOwnerUID enqueues owners regardless of their UIDs.
+	IgnoreOwnerUID OwnerUIDCheck = iota
+	// SkipOwnerUIDMismatch does not enqueue an owner whose UID differs from the recorded one, or that does
+	// not exist anymore, so that an owner recreated with the same name does not adopt stale dependents.
+	SkipOwnerUIDMismatch
+	// LogOwnerUIDMismatch enqueues an owner whose UID differs from the recorded one, and logs the mismatch.
+	// It helps finding stale dependents before switching to SkipOwnerUIDMismatch.
+	LogOwnerUIDMismatch
+)
+
+var (
+	_ inject.Cache  = &EnqueueRequestForAnnotation{}
+	_ inject.Scheme = &EnqueueRequestForAnnotation{}
+)
+
+// InjectCache is called by Controller.Watch to provide the cache used to look owners up.
+func (e *EnqueueRequestForAnnotation) InjectCache(c cache.Cache) error {
+	e.reader = c
+	return nil
+}
+
+// InjectScheme is called by Controller.Watch to provide the scheme used to create owner objects.
+func (e *EnqueueRequestForAnnotation) InjectScheme(s *runtime.Scheme) error {
+	e.scheme = s
+	return nil
+}
+
+// ownerUIDMatches returns false if req must not be enqueued for owner, recorded in the annotations of
+// object, because the UID of the current owner differs from the recorded one. Owners whose UID is not
+// recorded, or that cannot be looked up, are always enqueued.
+func (e *EnqueueRequestForAnnotation) ownerUIDMatches(object metav1.Object, owner annotatedOwner,
+	ownerType schema.GroupKind, req reconcile.Request) bool {
+	if e.OwnerUIDCheck == IgnoreOwnerUID || owner.UID == "" {
+		return true
+	}
+
+	current, err := e.newOwner(ownerType)
+	if err != nil {
+		log.Error(err, "Unable to check the UID of owner", "owner", req.NamespacedName, "type", owner.Type)
+		return true
+	}
+	var uid types.UID
+	if err := e.reader.Get(context.TODO(), req.NamespacedName, current); err == nil {
+		uid = current.GetUID()
+	} else if !apierrors.IsNotFound(err) {
+		log.Error(err, "Unable to check the UID of owner", "owner", req.NamespacedName, "type", owner.Type)
+		return true
+	}
+	if uid == owner.UID {
+		return true
+	}
+
+	log.Info("Owner UID does not match the UID recorded in the annotations of dependent",
+		"owner", req.NamespacedName, "type", owner.Type, "recordedUID", owner.UID, "uid", uid,
+		"dependent", types.NamespacedName{Namespace: object.GetNamespace(), Name: object.GetName()})
+	return e.OwnerUIDCheck == LogOwnerUIDMismatch
+}
+
+// newOwner returns an empty object of type ownerType, in its preferred version in the injected scheme.
+func (e *EnqueueRequestForAnnotation) newOwner(ownerType schema.GroupKind) (client.Object, error) {
+	if e.reader == nil || e.scheme == nil {
+		return nil, fmt.Errorf("no cache or scheme was injected")
+	}
+	return newObjectOfKind(e.scheme, ownerType)
+}
+
+// newObjectOfKind returns an empty object of kind gk, in its preferred version in s.
+func newObjectOfKind(s *runtime.Scheme, gk schema.GroupKind) (client.Object, error) {
+	for _, gv := range s.PrioritizedVersionsForGroup(gk.Group) {
+		gvk := gk.WithVersion(gv.Version)
+		if !s.Recognizes(gvk) {
+			continue
+		}
+		obj, err := s.New(gvk)
+		if err != nil {
+			return nil, err
+		}
+		if o, ok := obj.(client.Object); ok {
+			return o, nil
+		}
+	}
+	return nil, fmt.Errorf("no kind %s is registered in the scheme", gk)
+}
@@ -0,0 +1,127 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllertest"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var _ = Describe("Owner UIDs", func() {
+	var (
+		q        workqueue.RateLimitingInterface
+		instance *EnqueueRequestForAnnotation
+		role     *rbacv1.ClusterRole
+		owner    *corev1.Pod
+		request  reconcile.Request
+	)
+
+	BeforeEach(func() {
+		q = controllertest.Queue{Interface: workqueue.New()}
+		role = &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "dependent"}}
+		owner = &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "ns", Name: "owner", UID: "uid-1"}}
+		owner.SetGroupVersionKind(schema.GroupVersionKind{Version: "v1", Kind: "Pod"})
+		request = reconcile.Request{NamespacedName: types.NamespacedName{Namespace: "ns", Name: "owner"}}
+
+		instance = &EnqueueRequestForAnnotation{Type: schema.GroupKind{Kind: "Pod"}}
+		Expect(instance.InjectScheme(scheme.Scheme)).To(Succeed())
+	})
+
+	setCurrentOwner := func(uid types.UID) {
+		current := owner.DeepCopy()
+		current.UID = uid
+		instance.reader = fake.NewClientBuilder().WithObjects(current).Build()
+	}
+	enqueued := func() int {
+		instance.Create(event.CreateEvent{Object: role}, q)
+		n := q.Len()
+		for q.Len() > 0 {
+			i, _ := q.Get()
+			Expect(i).To(Equal(request))
+			q.Done(i)
+		}
+		return n
+	}
+
+	It("should record the UID of the owner", func() {
+		Expect(SetOwnerAnnotations(owner, role)).To(Succeed())
+		Expect(role.Annotations).To(HaveKeyWithValue(UIDAnnotation, "uid-1"))
+
+		shared := &rbacv1.ClusterRole{ObjectMeta: metav1.ObjectMeta{Name: "shared"}}
+		Expect(AddOwnerAnnotation(owner, shared)).To(Succeed())
+		Expect(shared.Annotations).To(Equal(map[string]string{
+			OwnersAnnotation: `[{"type":"Pod","namespacedName":"ns/owner","uid":"uid-1","version":"v1"}]`,
+		}))
+	})
+	It("should update the UID of a recreated owner", func() {
+		Expect(AddOwnerAnnotation(owner, role)).To(Succeed())
+		owner.UID = "uid-2"
+		Expect(AddOwnerAnnotation(owner, role)).To(Succeed())
+		Expect(role.Annotations).To(HaveKeyWithValue(OwnersAnnotation,
+			`[{"type":"Pod","namespacedName":"ns/owner","uid":"uid-2","version":"v1"}]`))
+	})
+	It("should enqueue owners regardless of their UIDs by default", func() {
+		Expect(SetOwnerAnnotations(owner, role)).To(Succeed())
+		setCurrentOwner("uid-2")
+		Expect(enqueued()).To(Equal(1))
+	})
+
+	Context("when skipping mismatches", func() {
+		BeforeEach(func() {
+			instance.OwnerUIDCheck = SkipOwnerUIDMismatch
+		})
+
+		It("should enqueue an owner whose UID matches", func() {
+			Expect(SetOwnerAnnotations(owner, role)).To(Succeed())
+			setCurrentOwner("uid-1")
+			Expect(enqueued()).To(Equal(1))
+		})
+		It("should not enqueue a recreated owner", func() {
+			Expect(AddOwnerAnnotation(owner, role)).To(Succeed())
+			setCurrentOwner("uid-2")
+			Expect(enqueued()).To(Equal(0))
+		})
+		It("should not enqueue a deleted owner", func() {
+			Expect(SetOwnerAnnotations(owner, role)).To(Succeed())
+			instance.reader = fake.NewClientBuilder().Build()
+			Expect(enqueued()).To(Equal(0))
+		})
+		It("should enqueue an owner whose UID is not recorded", func() {
+			owner.UID = ""
+			Expect(SetOwnerAnnotations(owner, role)).To(Succeed())
+			Expect(role.Annotations).NotTo(HaveKey(UIDAnnotation))
+			setCurrentOwner("uid-2")
+			Expect(enqueued()).To(Equal(1))
+		})
+	})
+
+	It("should enqueue a recreated owner when logging mismatches", func() {
+		instance.OwnerUIDCheck = LogOwnerUIDMismatch
+		Expect(SetOwnerAnnotations(owner, role)).To(Succeed())
+		setCurrentOwner("uid-2")
+		Expect(enqueued()).To(Equal(1))
+	})
+})
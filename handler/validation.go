@@ -0,0 +1,127 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/util/flowcontrol"
+
+	"github.com/operator-framework/operator-lib/handler/internal/metrics"
+)
+
+// MalformedAnnotationError is returned by ParseOwnerAnnotations for an annotation recording owners that is
+// malformed, or that is present without its counterpart.
+type MalformedAnnotationError struct {
+	Key    string
+	Value  string
+	Reason string
+}
+
+func (e *MalformedAnnotationError) Error() string {
+	return fmt.Sprintf("malformed annotation %s=%q: %s", e.Key, e.Value, e.Reason)
+}
+
+// kindPattern matches the valid kinds of owners.
+var kindPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*$`)
+
+// malformedLogLimiter limits the logs of malformed annotations, which are found again on every event of
+// the dependents recording them, to one every 10 seconds after a burst of 10.
+var malformedLogLimiter = flowcontrol.NewTokenBucketRateLimiter(0.1, 10)
+
+// ParseOwnerAnnotations returns the owners recorded in the annotations of obj with any of keys, defaulting
+// to DefaultAnnotationKeys. Namespaces must be valid DNS labels, names valid DNS subdomains, and types of
+// the form `<Kind>` or `<Kind>.<group>` with a valid DNS subdomain as group. If an annotation is malformed,
+// or present without its counterpart, the owners recorded in the valid annotations are returned along with
+// an aggregate of MalformedAnnotationErrors.
+func ParseOwnerAnnotations(obj metav1.Object, keys ...AnnotationKeys) ([]OwnerReference, error) {
+	if len(keys) == 0 {
+		keys = []AnnotationKeys{DefaultAnnotationKeys}
+	}
+
+	var refs []OwnerReference
+	var errs []error
+	for _, k := range keys {
+		owners, parseErrs := k.parseAnnotatedOwners(obj.GetAnnotations())
+		errs = append(errs, parseErrs...)
+		for _, owner := range owners {
+			ref, err := owner.reference()
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			refs = append(refs, ref)
+		}
+	}
+	return refs, utilerrors.NewAggregate(errs)
+}
+
+// reference validates o and returns the OwnerReference it describes.
+func (o annotatedOwner) reference() (OwnerReference, error) {
+	malformed := func(value, format string, args ...interface{}) error {
+		return &MalformedAnnotationError{Key: o.key, Value: value, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	gk := schema.ParseGroupKind(o.Type)
+	if !kindPattern.MatchString(gk.Kind) {
+		return OwnerReference{}, malformed(o.Type, "invalid kind %q", gk.Kind)
+	}
+	if gk.Group != "" {
+		if errs := validation.IsDNS1123Subdomain(gk.Group); len(errs) > 0 {
+			return OwnerReference{}, malformed(o.Type, "invalid group %q: %s", gk.Group, strings.Join(errs, ", "))
+		}
+	}
+
+	var nsn types.NamespacedName
+	switch values := strings.Split(o.NamespacedName, "/"); len(values) {
+	case 1:
+		nsn.Name = values[0]
+	case 2:
+		nsn.Namespace, nsn.Name = values[0], values[1]
+	default:
+		return OwnerReference{}, malformed(o.NamespacedName, "not of the form <namespace>/<name> or <name>")
+	}
+	if nsn.Namespace != "" {
+		if errs := validation.IsDNS1123Label(nsn.Namespace); len(errs) > 0 {
+			return OwnerReference{}, malformed(o.NamespacedName, "invalid namespace %q: %s", nsn.Namespace,
+				strings.Join(errs, ", "))
+		}
+	}
+	if errs := validation.IsDNS1123Subdomain(nsn.Name); len(errs) > 0 {
+		return OwnerReference{}, malformed(o.NamespacedName, "invalid name %q: %s", nsn.Name, strings.Join(errs, ", "))
+	}
+
+	return OwnerReference{Type: gk, NamespacedName: nsn, UID: o.UID, Version: o.Version}, nil
+}
+
+// reportMalformedAnnotation counts err in the malformed annotations metric, and logs it unless too many
+// were logged recently.
+func reportMalformedAnnotation(err error) {
+	key := ""
+	if malformed, ok := err.(*MalformedAnnotationError); ok {
+		key = malformed.Key
+	}
+	metrics.MalformedOwnerAnnotations.WithLabelValues(key).Inc()
+	if malformedLogLimiter.TryAccept() {
+		log.Info("Ignoring malformed owner annotation", "error", err.Error())
+	}
+}
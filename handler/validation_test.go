@@ -0,0 +1,154 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package handler
+
+import (
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllertest"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	"github.com/operator-framework/operator-lib/handler/internal/metrics"
+)
+
+var _ = Describe("Owner annotation validation", func() {
+	objectWith := func(annotations map[string]string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "dependent", Annotations: annotations}}
+	}
+	malformedKeys := func(err error) []string {
+		var agg utilerrors.Aggregate
+		Expect(errors.As(err, &agg)).To(BeTrue())
+		var keys []string
+		for _, e := range agg.Errors() {
+			var malformed *MalformedAnnotationError
+			Expect(errors.As(e, &malformed)).To(BeTrue())
+			keys = append(keys, malformed.Key)
+		}
+		return keys
+	}
+
+	Describe("ParseOwnerAnnotations", func() {
+		It("should return the owners recorded in the annotations", func() {
+			refs, err := ParseOwnerAnnotations(objectWith(map[string]string{
+				TypeAnnotation:           "ReplicaSet.apps",
+				NamespacedNameAnnotation: "ns/my-replicaset",
+				UIDAnnotation:            "uid-1",
+				OwnersAnnotation: `[{"type":"Node","namespacedName":"/node-1"},` +
+					`{"type":"ClusterRole.rbac.authorization.k8s.io","namespacedName":"admin"}]`,
+			}))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(refs).To(Equal([]OwnerReference{
+				{
+					Type:           schema.GroupKind{Group: "apps", Kind: "ReplicaSet"},
+					NamespacedName: types.NamespacedName{Namespace: "ns", Name: "my-replicaset"},
+					UID:            "uid-1",
+				},
+				{Type: schema.GroupKind{Kind: "Node"}, NamespacedName: types.NamespacedName{Name: "node-1"}},
+				{
+					Type:           schema.GroupKind{Group: "rbac.authorization.k8s.io", Kind: "ClusterRole"},
+					NamespacedName: types.NamespacedName{Name: "admin"},
+				},
+			}))
+		})
+		It("should return the owners recorded with the given keys", func() {
+			keys := AnnotationKeysWithPrefix("example.com")
+			refs, err := ParseOwnerAnnotations(objectWith(map[string]string{
+				TypeAnnotation:                      "Pod",
+				NamespacedNameAnnotation:            "ns/default",
+				"example.com/primary-resource-type": "Pod",
+				"example.com/primary-resource":      "ns/prefixed",
+			}), keys)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(refs).To(HaveLen(1))
+			Expect(refs[0].NamespacedName.Name).To(Equal("prefixed"))
+		})
+		It("should reject invalid names, namespaces and types", func() {
+			for _, owner := range []string{
+				`{"type":"Pod","namespacedName":"ns/My-Pod"}`,
+				`{"type":"Pod","namespacedName":"my_ns/pod"}`,
+				`{"type":"Pod","namespacedName":"ns/pod/extra"}`,
+				`{"type":"Pod","namespacedName":""}`,
+				`{"type":"","namespacedName":"ns/pod"}`,
+				`{"type":"Replica Set.apps","namespacedName":"ns/pod"}`,
+				`{"type":"ReplicaSet.Apps_Group","namespacedName":"ns/pod"}`,
+			} {
+				refs, err := ParseOwnerAnnotations(objectWith(map[string]string{OwnersAnnotation: "[" + owner + "]"}))
+				Expect(err).To(HaveOccurred(), owner)
+				Expect(refs).To(BeEmpty(), owner)
+			}
+		})
+		It("should report half-present and invalid annotations and return the valid owners", func() {
+			refs, err := ParseOwnerAnnotations(objectWith(map[string]string{
+				TypeAnnotation:   "Pod",
+				OwnersAnnotation: `not json`,
+			}))
+			Expect(refs).To(BeEmpty())
+			Expect(malformedKeys(err)).To(ConsistOf(TypeAnnotation, OwnersAnnotation))
+
+			refs, err = ParseOwnerAnnotations(objectWith(map[string]string{
+				NamespacedNameAnnotation: "ns/pod",
+				OwnersAnnotation:         `[{"type":"Pod","namespacedName":"ns/Pod"},{"type":"Pod","namespacedName":"ns/pod"}]`,
+			}))
+			Expect(refs).To(HaveLen(1))
+			Expect(malformedKeys(err)).To(ConsistOf(NamespacedNameAnnotation, OwnersAnnotation))
+		})
+	})
+
+	Describe("EnqueueRequestForAnnotation", func() {
+		var (
+			q        workqueue.RateLimitingInterface
+			instance EnqueueRequestForAnnotation
+			object   *corev1.ConfigMap
+		)
+		BeforeEach(func() {
+			q = controllertest.Queue{Interface: workqueue.New()}
+			instance = EnqueueRequestForAnnotation{Type: schema.GroupKind{Kind: "Pod"}}
+			object = objectWith(map[string]string{
+				TypeAnnotation:           "Pod",
+				NamespacedNameAnnotation: "ns/Invalid_Name",
+			})
+		})
+
+		It("should enqueue and report owners with invalid names", func() {
+			before := testutil.ToFloat64(metrics.MalformedOwnerAnnotations.WithLabelValues(NamespacedNameAnnotation))
+			instance.Create(event.CreateEvent{Object: object}, q)
+			Expect(q.Len()).To(Equal(1))
+			Expect(testutil.ToFloat64(metrics.MalformedOwnerAnnotations.WithLabelValues(NamespacedNameAnnotation))).
+				To(Equal(before + 1))
+		})
+		It("should drop owners with invalid names when strict", func() {
+			instance.Strict = true
+			instance.Create(event.CreateEvent{Object: object}, q)
+			Expect(q.Len()).To(Equal(0))
+		})
+		It("should report half-present annotations", func() {
+			before := testutil.ToFloat64(metrics.MalformedOwnerAnnotations.WithLabelValues(TypeAnnotation))
+			delete(object.Annotations, NamespacedNameAnnotation)
+			instance.Create(event.CreateEvent{Object: object}, q)
+			Expect(q.Len()).To(Equal(0))
+			Expect(testutil.ToFloat64(metrics.MalformedOwnerAnnotations.WithLabelValues(TypeAnnotation))).
+				To(Equal(before + 1))
+		})
+	})
+})
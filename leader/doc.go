@@ -50,5 +50,11 @@ env:
     valueFrom:
       fieldRef:
         fieldPath: metadata.name
+
+Outside of a pod, e.g. when running an operator locally during development,
+WithOffCluster must be given to Become. The current process is then identified
+by its hostname and process ID, and takes the lock in the cluster configured
+by its kubeconfig, renewing it with heartbeats as there is no pod to own it.
+If no cluster is configured, the lock is a local file.
 */
 package leader
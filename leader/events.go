@@ -0,0 +1,82 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leader
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Reasons of the Kubernetes events recorded by Become. See WithEventRecorder.
+const (
+	// ReasonBecameLeader is recorded when a pod becomes the leader.
+	ReasonBecameLeader = "BecameLeader"
+	// ReasonEvictedLeaderDeleted is recorded when an evicted leader pod is
+	// deleted so that its lock can be taken over.
+	ReasonEvictedLeaderDeleted = "EvictedLeaderDeleted"
+	// ReasonNotReadyLeaderReplaced is recorded when a leader pod on a NotReady
+	// node is deleted along with its lock.
+	ReasonNotReadyLeaderReplaced = "NotReadyLeaderReplaced"
+	// ReasonOlderLeaderReplaced is recorded when a leader pod running an older
+	// operator version is deleted so that it can be replaced. See
+	// WithVersionHandoff.
+	ReasonOlderLeaderReplaced = "OlderLeaderReplaced"
+)
+
+// WithEventRecorder returns an Option that records Kubernetes events on the
+// lock and on the pods involved when a pod becomes the leader, when an
+// evicted leader is deleted, and when a leader is replaced because its node is
+// NotReady or it runs an older operator version. A recorder can be obtained
+// from a manager with GetEventRecorderFor. This requires permission to create
+// and patch events.
+func WithEventRecorder(recorder record.EventRecorder) Option {
+	return func(c *Config) error {
+		c.Recorder = recorder
+		return nil
+	}
+}
+
+// recordEvent records an event on each of objs if a recorder is configured.
+func (c *Config) recordEvent(eventType, reason, message string, objs ...crclient.Object) {
+	if c.Recorder == nil || c.disabled[featureEvents] {
+		return
+	}
+	for _, obj := range objs {
+		c.Recorder.Event(obj, eventType, reason, message)
+	}
+}
+
+// recordTakeover records an event on lock and leaderPod, describing why the
+// current pod is replacing the leader.
+func (c *Config) recordTakeover(lock *corev1.ConfigMap, leaderPod *corev1.Pod, reason, message string) {
+	c.recordEvent(corev1.EventTypeWarning, reason, message, lock, leaderPod)
+}
+
+// recordBecameLeader records an event on lock and on the pod referenced by
+// owner, if any.
+func (c *Config) recordBecameLeader(lock *corev1.ConfigMap, owner *metav1.OwnerReference) {
+	if owner == nil {
+		c.recordEvent(corev1.EventTypeNormal, ReasonBecameLeader,
+			fmt.Sprintf("%s became the leader", c.Identity), lock)
+		return
+	}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: owner.Name, Namespace: lock.Namespace, UID: owner.UID}}
+	c.recordEvent(corev1.EventTypeNormal, ReasonBecameLeader,
+		fmt.Sprintf("Pod %s became the leader", owner.Name), lock, pod)
+}
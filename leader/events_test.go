@@ -0,0 +1,119 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leader
+
+import (
+	"context"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("WithEventRecorder", func() {
+	var (
+		client   crclient.Client
+		recorder *record.FakeRecorder
+		leader   *corev1.Pod
+	)
+	lock := func() *corev1.ConfigMap {
+		return &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+			Name:      "leader-test",
+			Namespace: "testns",
+			OwnerReferences: []metav1.OwnerReference{
+				{APIVersion: "v1", Kind: "Pod", Name: "pod-a", UID: "a"},
+			},
+		}}
+	}
+	drain := func() []string {
+		var events []string
+		for {
+			select {
+			case e := <-recorder.Events:
+				events = append(events, e)
+			default:
+				return events
+			}
+		}
+	}
+	BeforeEach(func() {
+		readNamespace = func() (string, error) {
+			return "testns", nil
+		}
+		os.Setenv("POD_NAME", "pod-b")
+		recorder = record.NewFakeRecorder(10)
+		leader = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod-a", Namespace: "testns", UID: "a"},
+			Spec:       corev1.PodSpec{NodeName: "node-a"},
+		}
+	})
+
+	It("should record the new leader on the lock and the pod", func() {
+		client = fake.NewClientBuilder().WithObjects(
+			&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-b", Namespace: "testns", UID: "b"}},
+		).Build()
+		Expect(Become(context.TODO(), "leader-test", WithClient(client), WithEventRecorder(recorder))).To(Succeed())
+		Expect(drain()).To(Equal([]string{
+			"Normal BecameLeader Pod pod-b became the leader",
+			"Normal BecameLeader Pod pod-b became the leader",
+		}))
+	})
+	It("should record the deletion of an evicted leader", func() {
+		leader.Status = corev1.PodStatus{Phase: corev1.PodFailed, Reason: "Evicted"}
+		client = fake.NewClientBuilder().WithObjects(
+			leader,
+			&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-b", Namespace: "testns", UID: "b"}},
+			lock(),
+		).Build()
+		ctx, cancel := context.WithCancel(context.TODO())
+		defer cancel()
+		observer := ObserverFunc(func(e Event) {
+			if e.Type == EventEvictingLeader {
+				cancel()
+			}
+		})
+		err := Become(ctx, "leader-test", WithClient(client), WithEventRecorder(recorder), WithObserver(observer))
+		Expect(err).To(Equal(context.Canceled))
+		Expect(drain()).To(Equal([]string{
+			"Warning EvictedLeaderDeleted Deleted evicted leader pod pod-a",
+			"Warning EvictedLeaderDeleted Deleted evicted leader pod pod-a",
+		}))
+	})
+	It("should record the replacement of a leader on a NotReady node", func() {
+		client = fake.NewClientBuilder().WithObjects(
+			&corev1.Node{
+				ObjectMeta: metav1.ObjectMeta{Name: "node-a"},
+				Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+					{Type: corev1.NodeReady, Status: corev1.ConditionFalse},
+				}},
+			},
+			leader,
+			&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-b", Namespace: "testns", UID: "b"}},
+			lock(),
+		).Build()
+		Expect(Become(context.TODO(), "leader-test", WithClient(client), WithEventRecorder(recorder))).To(Succeed())
+		Expect(drain()).To(Equal([]string{
+			"Warning NotReadyLeaderReplaced Deleted leader pod pod-a and its lock as node node-a is not ready",
+			"Warning NotReadyLeaderReplaced Deleted leader pod pod-a and its lock as node node-a is not ready",
+			"Normal BecameLeader Pod pod-b became the leader",
+			"Normal BecameLeader Pod pod-b became the leader",
+		}))
+	})
+})
@@ -0,0 +1,318 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// FencingTokenAnnotation is set by the client returned by NewFencedClient on
+// every object it writes. Its value is the generation of the leader that
+// wrote the object.
+const FencingTokenAnnotation = "operator-lib/leader-generation"
+
+// ErrStaleLeader indicates that a write was rejected because the current pod
+// is no longer the leader, or a newer leader has written the object.
+var ErrStaleLeader = errors.New("stale leader")
+
+// Leadership is a handle on the leadership acquired by Acquire.
+type Leadership struct {
+	// Namespace and LockName identify the lock.
+	Namespace string
+	LockName  string
+	// PodName and PodUID identify the leader pod.
+	PodName string
+	PodUID  types.UID
+	// Identity is the stable identity of the leader, if any. Leaderships
+	// acquired off-cluster are identified by Identity only.
+	Identity string
+	// Generation is the fencing token of this leadership. Every time a pod
+	// acquires the lock, the generation is incremented, so a leader with a
+	// lower generation than the one recorded in the lock has been replaced.
+	Generation int64
+
+	client crclient.Client
+	// holder is set if the leadership was acquired off-cluster. The lock
+	// then records its holder in an annotation instead of an owner reference.
+	holder string
+	// released is closed once a file lock acquired off-cluster is released.
+	released <-chan struct{}
+}
+
+// Verify returns ErrStaleLeader if the lock no longer exists, is owned by a
+// different pod, or records a different generation.
+func (l *Leadership) Verify(ctx context.Context) error {
+	if l.released != nil {
+		select {
+		case <-l.released:
+			return fmt.Errorf("%w: file lock %s was released", ErrStaleLeader, l.LockName)
+		default:
+			return nil
+		}
+	}
+	lock := &corev1.ConfigMap{}
+	err := l.client.Get(ctx, crclient.ObjectKey{Namespace: l.Namespace, Name: l.LockName}, lock)
+	switch {
+	case apierrors.IsNotFound(err):
+		return fmt.Errorf("%w: lock %s/%s not found", ErrStaleLeader, l.Namespace, l.LockName)
+	case err != nil:
+		return err
+	}
+	if l.holder != "" {
+		if lock.Annotations[HolderAnnotation] != l.holder {
+			return fmt.Errorf("%w: lock %s/%s is held by another process", ErrStaleLeader, l.Namespace, l.LockName)
+		}
+	} else if !isOwnedBy(lock, &metav1.OwnerReference{Name: l.PodName, UID: l.PodUID}) {
+		return fmt.Errorf("%w: lock %s/%s is owned by another pod", ErrStaleLeader, l.Namespace, l.LockName)
+	}
+	if generation, _ := parseGeneration(lock.Data[generationKey]); generation != l.Generation {
+		return fmt.Errorf("%w: lock generation is %d, ours is %d", ErrStaleLeader, generation, l.Generation)
+	}
+	return nil
+}
+
+// GenerationCounterLabel is set to "true" on the ConfigMaps holding the last
+// generation handed out for each lock, named after the lock with a
+// "-generation" suffix. Unlike locks, counters have no owner and are left
+// behind when their lock is deleted, so that generations keep increasing
+// across leaders: removing a counter while objects written with
+// NewFencedClient remain would make the next leaders reuse their generations.
+// ListLocks and RemoveOrphanedLocks leave counters alone. They can be deleted
+// with this label once the operator is uninstalled.
+const GenerationCounterLabel = "operator-lib/leader-generation-counter"
+
+// generationCounterName returns the name of the ConfigMap holding the last
+// generation handed out for lockName.
+func generationCounterName(lockName string) string {
+	return lockName + "-generation"
+}
+
+// lockHolder returns a string identifying the holder of lock, whether it is
+// owned by a pod or held off-cluster.
+func lockHolder(lock *corev1.ConfigMap) string {
+	if holder, ok := lock.Annotations[HolderAnnotation]; ok && len(lock.GetOwnerReferences()) == 0 {
+		return holder
+	}
+	var owners []string
+	for _, ref := range lock.GetOwnerReferences() {
+		owners = append(owners, ref.Name+"/"+string(ref.UID))
+	}
+	return strings.Join(owners, ",")
+}
+
+// ensureGeneration returns the generation recorded in lock, recording the
+// next generation if there is none. It returns ErrLockLost if the lock changed
+// hands, or another holder recorded its generation, while recording it.
+func (c *Config) ensureGeneration(ctx context.Context, lock *corev1.ConfigMap) (int64, error) {
+	if generation, err := parseGeneration(lock.Data[generationKey]); err == nil {
+		return generation, nil
+	}
+	generation, err := nextGeneration(ctx, c.Client, lock.Namespace, lock.Name)
+	if err != nil {
+		return 0, err
+	}
+
+	holder := lockHolder(lock)
+	key := crclient.ObjectKey{Namespace: lock.Namespace, Name: lock.Name}
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		// update a copy, so that a failed update does not leave the
+		// generation in lock for a retry to mistake as recorded
+		updated := lock.DeepCopy()
+		if updated.Data == nil {
+			updated.Data = map[string]string{}
+		}
+		updated.Data[generationKey] = strconv.FormatInt(generation, 10)
+		err := c.Client.Update(ctx, updated)
+		if err == nil {
+			*lock = *updated
+			return nil
+		}
+		if !apierrors.IsConflict(err) {
+			return err
+		}
+		current := &corev1.ConfigMap{}
+		if err := c.Client.Get(ctx, key, current); err != nil {
+			if apierrors.IsNotFound(err) {
+				return fmt.Errorf("%w: ConfigMap %s not found", ErrLockLost, key)
+			}
+			return err
+		}
+		*lock = *current
+		if now := lockHolder(lock); now != holder {
+			return fmt.Errorf("%w: ConfigMap %s is held by %q", ErrLockLost, key, now)
+		}
+		if recorded, ok := lock.Data[generationKey]; ok {
+			return fmt.Errorf("%w: ConfigMap %s records generation %s", ErrLockLost, key, recorded)
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	log.Info("Recorded leader generation.", "generation", generation)
+	return generation, nil
+}
+
+// nextGeneration increments and returns the generation counter of lockName.
+func nextGeneration(ctx context.Context, client crclient.Client, ns, lockName string) (int64, error) {
+	var generation int64
+	isRetriable := func(err error) bool {
+		return apierrors.IsConflict(err) || apierrors.IsAlreadyExists(err)
+	}
+	err := retry.OnError(retry.DefaultRetry, isRetriable, func() error {
+		counter := &corev1.ConfigMap{}
+		key := crclient.ObjectKey{Namespace: ns, Name: generationCounterName(lockName)}
+		err := client.Get(ctx, key, counter)
+		switch {
+		case apierrors.IsNotFound(err):
+			generation = 1
+			counter.Name = key.Name
+			counter.Namespace = key.Namespace
+			counter.Labels = map[string]string{GenerationCounterLabel: "true"}
+			counter.Data = map[string]string{generationKey: "1"}
+			return client.Create(ctx, counter)
+		case err != nil:
+			return err
+		}
+		last, err := parseGeneration(counter.Data[generationKey])
+		if err != nil {
+			return fmt.Errorf("invalid generation counter %s: %w", key, err)
+		}
+		generation = last + 1
+		counter.Data[generationKey] = strconv.FormatInt(generation, 10)
+		// counters created by earlier versions of this package are not labeled
+		if counter.Labels == nil {
+			counter.Labels = map[string]string{}
+		}
+		counter.Labels[GenerationCounterLabel] = "true"
+		return client.Update(ctx, counter)
+	})
+	return generation, err
+}
+
+func parseGeneration(value string) (int64, error) {
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// NewFencedClient returns a Client that only writes while l is the current
+// leadership. Before every create, update, patch or delete, it verifies l and
+// returns ErrStaleLeader if a newer leader has taken over. It also sets
+// FencingTokenAnnotation on the objects it creates, updates or patches, and
+// refuses to update or patch an object whose annotation shows it was written
+// by a newer leader, so that writes of stale leaders can be detected by other
+// parties as well.
+//
+// The annotation is only included in patches computed from the object, such
+// as those created with client.MergeFrom.
+func NewFencedClient(cl crclient.Client, l *Leadership) crclient.Client {
+	return &fencedClient{Client: cl, leadership: l}
+}
+
+type fencedClient struct {
+	crclient.Client
+	leadership *Leadership
+}
+
+// fence verifies the leadership and annotates obj with its generation.
+func (c *fencedClient) fence(ctx context.Context, obj crclient.Object) error {
+	if err := c.leadership.Verify(ctx); err != nil {
+		return err
+	}
+	if obj == nil {
+		return nil
+	}
+	annotations := obj.GetAnnotations()
+	if value, ok := annotations[FencingTokenAnnotation]; ok {
+		if written, err := parseGeneration(value); err == nil && written > c.leadership.Generation {
+			return fmt.Errorf("%w: %s was written by generation %d, ours is %d",
+				ErrStaleLeader, obj.GetName(), written, c.leadership.Generation)
+		}
+	}
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[FencingTokenAnnotation] = strconv.FormatInt(c.leadership.Generation, 10)
+	obj.SetAnnotations(annotations)
+	return nil
+}
+
+func (c *fencedClient) Create(ctx context.Context, obj crclient.Object, opts ...crclient.CreateOption) error {
+	if err := c.fence(ctx, obj); err != nil {
+		return err
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *fencedClient) Update(ctx context.Context, obj crclient.Object, opts ...crclient.UpdateOption) error {
+	if err := c.fence(ctx, obj); err != nil {
+		return err
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *fencedClient) Patch(ctx context.Context, obj crclient.Object, patch crclient.Patch, opts ...crclient.PatchOption) error {
+	if err := c.fence(ctx, obj); err != nil {
+		return err
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func (c *fencedClient) Delete(ctx context.Context, obj crclient.Object, opts ...crclient.DeleteOption) error {
+	if err := c.leadership.Verify(ctx); err != nil {
+		return err
+	}
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func (c *fencedClient) DeleteAllOf(ctx context.Context, obj crclient.Object, opts ...crclient.DeleteAllOfOption) error {
+	if err := c.leadership.Verify(ctx); err != nil {
+		return err
+	}
+	return c.Client.DeleteAllOf(ctx, obj, opts...)
+}
+
+func (c *fencedClient) Status() crclient.StatusWriter {
+	return &fencedStatusWriter{StatusWriter: c.Client.Status(), leadership: c.leadership}
+}
+
+type fencedStatusWriter struct {
+	crclient.StatusWriter
+	leadership *Leadership
+}
+
+func (w *fencedStatusWriter) Update(ctx context.Context, obj crclient.Object, opts ...crclient.UpdateOption) error {
+	if err := w.leadership.Verify(ctx); err != nil {
+		return err
+	}
+	return w.StatusWriter.Update(ctx, obj, opts...)
+}
+
+func (w *fencedStatusWriter) Patch(ctx context.Context, obj crclient.Object, patch crclient.Patch, opts ...crclient.PatchOption) error {
+	if err := w.leadership.Verify(ctx); err != nil {
+		return err
+	}
+	return w.StatusWriter.Patch(ctx, obj, patch, opts...)
+}
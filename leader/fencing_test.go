@@ -0,0 +1,178 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leader
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Fencing", func() {
+	var (
+		client crclient.Client
+	)
+	lockKey := crclient.ObjectKey{Namespace: "testns", Name: "leader-test"}
+	deleteLock := func() {
+		lock := &corev1.ConfigMap{}
+		Expect(client.Get(context.TODO(), lockKey, lock)).To(Succeed())
+		Expect(client.Delete(context.TODO(), lock)).To(Succeed())
+	}
+	acquireAs := func(podName string) *Leadership {
+		os.Setenv("POD_NAME", podName)
+		l, err := Acquire(context.TODO(), "leader-test", WithClient(client))
+		Expect(err).Should(BeNil())
+		return l
+	}
+	BeforeEach(func() {
+		readNamespace = func() (string, error) {
+			return "testns", nil
+		}
+		client = fake.NewClientBuilder().WithObjects(
+			&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-a", Namespace: "testns", UID: "a"}},
+			&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-b", Namespace: "testns", UID: "b"}},
+		).Build()
+	})
+
+	It("should increment the generation on every takeover", func() {
+		first := acquireAs("pod-a")
+		Expect(first.Generation).To(BeEquivalentTo(1))
+		Expect(first.PodName).To(Equal("pod-a"))
+
+		deleteLock()
+		second := acquireAs("pod-b")
+		Expect(second.Generation).To(BeEquivalentTo(2))
+
+		info, err := GetLeader(context.TODO(), client, "testns", "leader-test")
+		Expect(err).Should(BeNil())
+		Expect(info.Generation).To(BeEquivalentTo(2))
+	})
+	It("should keep the generation when the leader restarts", func() {
+		first := acquireAs("pod-a")
+		again := acquireAs("pod-a")
+		Expect(again.Generation).To(Equal(first.Generation))
+	})
+
+	It("should label the generation counter", func() {
+		acquireAs("pod-a")
+		counter := &corev1.ConfigMap{}
+		key := crclient.ObjectKey{Namespace: "testns", Name: generationCounterName("leader-test")}
+		Expect(client.Get(context.TODO(), key, counter)).To(Succeed())
+		Expect(counter.Labels).To(HaveKeyWithValue(GenerationCounterLabel, "true"))
+
+		locks, err := ListLocks(context.TODO(), client, "testns")
+		Expect(err).Should(BeNil())
+		Expect(locks).To(HaveLen(1))
+		Expect(locks[0].Name).To(Equal("leader-test"))
+	})
+
+	Describe("ensureGeneration", func() {
+		var stale *corev1.ConfigMap
+		BeforeEach(func() {
+			stale = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+				Name:            lockKey.Name,
+				Namespace:       lockKey.Namespace,
+				OwnerReferences: []metav1.OwnerReference{{APIVersion: "v1", Kind: "Pod", Name: "pod-a", UID: "a"}},
+			}}
+			Expect(client.Create(context.TODO(), stale)).To(Succeed())
+		})
+		changeLock := func(change func(*corev1.ConfigMap)) {
+			current := &corev1.ConfigMap{}
+			Expect(client.Get(context.TODO(), lockKey, current)).To(Succeed())
+			change(current)
+			Expect(client.Update(context.TODO(), current)).To(Succeed())
+		}
+
+		It("should record the generation after an unrelated change", func() {
+			changeLock(func(lock *corev1.ConfigMap) { lock.Labels = lockLabels() })
+			generation, err := (&Config{Client: client}).ensureGeneration(context.TODO(), stale)
+			Expect(err).Should(BeNil())
+			Expect(generation).To(BeEquivalentTo(1))
+		})
+		It("should not record the generation once the lock changed hands", func() {
+			changeLock(func(lock *corev1.ConfigMap) {
+				lock.OwnerReferences[0].Name, lock.OwnerReferences[0].UID = "pod-b", "b"
+			})
+			_, err := (&Config{Client: client}).ensureGeneration(context.TODO(), stale)
+			Expect(errors.Is(err, ErrLockLost)).To(BeTrue())
+
+			lock := &corev1.ConfigMap{}
+			Expect(client.Get(context.TODO(), lockKey, lock)).To(Succeed())
+			Expect(lock.Data).NotTo(HaveKey(generationKey))
+		})
+		It("should not overwrite a generation recorded meanwhile", func() {
+			changeLock(func(lock *corev1.ConfigMap) {
+				lock.Data = map[string]string{generationKey: "7"}
+			})
+			_, err := (&Config{Client: client}).ensureGeneration(context.TODO(), stale)
+			Expect(errors.Is(err, ErrLockLost)).To(BeTrue())
+
+			lock := &corev1.ConfigMap{}
+			Expect(client.Get(context.TODO(), lockKey, lock)).To(Succeed())
+			Expect(lock.Data).To(HaveKeyWithValue(generationKey, "7"))
+		})
+	})
+
+	Describe("Verify", func() {
+		It("should succeed while the leadership is current", func() {
+			Expect(acquireAs("pod-a").Verify(context.TODO())).To(Succeed())
+		})
+		It("should fail once the lock is lost", func() {
+			l := acquireAs("pod-a")
+			deleteLock()
+			Expect(errors.Is(l.Verify(context.TODO()), ErrStaleLeader)).To(BeTrue())
+		})
+		It("should fail once another pod took over", func() {
+			l := acquireAs("pod-a")
+			deleteLock()
+			acquireAs("pod-b")
+			Expect(errors.Is(l.Verify(context.TODO()), ErrStaleLeader)).To(BeTrue())
+		})
+	})
+
+	Describe("NewFencedClient", func() {
+		var obj *corev1.ConfigMap
+		BeforeEach(func() {
+			obj = &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "dependent", Namespace: "testns"}}
+		})
+		It("should annotate writes with the generation", func() {
+			fenced := NewFencedClient(client, acquireAs("pod-a"))
+			Expect(fenced.Create(context.TODO(), obj)).To(Succeed())
+			Expect(obj.Annotations).To(HaveKeyWithValue(FencingTokenAnnotation, "1"))
+		})
+		It("should reject writes of a stale leader", func() {
+			stale := NewFencedClient(client, acquireAs("pod-a"))
+			deleteLock()
+			acquireAs("pod-b")
+			Expect(errors.Is(stale.Create(context.TODO(), obj), ErrStaleLeader)).To(BeTrue())
+			Expect(errors.Is(stale.Delete(context.TODO(), obj), ErrStaleLeader)).To(BeTrue())
+			Expect(errors.Is(stale.Status().Update(context.TODO(), obj), ErrStaleLeader)).To(BeTrue())
+		})
+		It("should reject updates of objects written by a newer leader", func() {
+			l := acquireAs("pod-a")
+			obj.Annotations = map[string]string{FencingTokenAnnotation: "5"}
+			Expect(client.Create(context.TODO(), obj)).To(Succeed())
+			err := NewFencedClient(client, l).Update(context.TODO(), obj)
+			Expect(errors.Is(err, ErrStaleLeader)).To(BeTrue())
+		})
+	})
+})
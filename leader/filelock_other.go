@@ -0,0 +1,30 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package leader
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+)
+
+// lockFile is not supported on this platform, so off-cluster elections
+// require a cluster.
+func lockFile(path string) (*os.File, error) {
+	return nil, fmt.Errorf("local file locks are not supported on %s, configure a cluster instead", runtime.GOOS)
+}
@@ -0,0 +1,138 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+)
+
+var (
+	// ErrNotLeader indicates that the current pod has not become the leader.
+	ErrNotLeader = errors.New("not the leader")
+
+	// ErrLockLost indicates that the current pod became the leader, but the
+	// lock no longer exists or is owned by a different pod.
+	ErrLockLost = errors.New("leader lock lost")
+)
+
+var (
+	_ healthz.Checker = (&Checker{}).Liveness
+	_ healthz.Checker = (&Checker{}).Readiness
+)
+
+// Checker reports on the state of the leader lock held by the current pod.
+// Its Liveness and Readiness methods are healthz.Checkers, so they can be
+// registered with a controller-runtime manager:
+//
+//	checker := leader.NewChecker()
+//	mgr.AddHealthzCheck("leader", checker.Liveness)
+//	mgr.AddReadyzCheck("leader", checker.Readiness)
+//	err := leader.Become(ctx, "my-operator-lock", leader.WithChecker(checker))
+//
+// Since the manager serves health probes before Become returns, a pod that is
+// still waiting to become the leader is considered alive.
+type Checker struct {
+	mu     sync.RWMutex
+	client crclient.Client
+	key    crclient.ObjectKey
+	owner  *metav1.OwnerReference
+}
+
+// NewChecker returns a Checker that has not yet observed leadership.
+func NewChecker() *Checker {
+	return &Checker{}
+}
+
+// WithChecker returns an Option that notifies c once Become acquires the lock
+func WithChecker(c *Checker) Option {
+	return func(config *Config) error {
+		config.Checker = c
+		return nil
+	}
+}
+
+// IsLeader returns true if Become has acquired the lock for this pod. It does
+// not verify that the lock is still held; use Readiness for that.
+func (c *Checker) IsLeader() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.owner != nil
+}
+
+// Liveness returns an error if the current pod became the leader but no longer
+// holds the lock. Pods that are not (yet) the leader are considered alive, as
+// are leaders whose lock could not be read due to an API error.
+func (c *Checker) Liveness(req *http.Request) error {
+	if !c.IsLeader() {
+		return nil
+	}
+	err := c.checkLock(req.Context())
+	if errors.Is(err, ErrLockLost) {
+		return err
+	}
+	if err != nil {
+		log.Error(err, "Unable to verify leader lock for liveness check")
+	}
+	return nil
+}
+
+// Readiness returns nil only if the current pod is the leader and still holds
+// the lock. It can be used to route traffic from a Service to the leader only.
+func (c *Checker) Readiness(req *http.Request) error {
+	if !c.IsLeader() {
+		return ErrNotLeader
+	}
+	return c.checkLock(req.Context())
+}
+
+func (c *Checker) setLeader(client crclient.Client, key crclient.ObjectKey, owner *metav1.OwnerReference) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.client = client
+	c.key = key
+	c.owner = owner
+}
+
+// checkLock verifies that the lock exists and is owned by the current pod.
+func (c *Checker) checkLock(ctx context.Context) error {
+	c.mu.RLock()
+	client, key, owner := c.client, c.key, c.owner
+	c.mu.RUnlock()
+
+	lock := &corev1.ConfigMap{}
+	err := client.Get(ctx, key, lock)
+	switch {
+	case apierrors.IsNotFound(err):
+		return fmt.Errorf("%w: ConfigMap %s not found", ErrLockLost, key)
+	case err != nil:
+		return err
+	}
+	for _, ref := range lock.GetOwnerReferences() {
+		if ref.UID == owner.UID && ref.Name == owner.Name {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: ConfigMap %s is not owned by %s", ErrLockLost, key, owner.Name)
+}
@@ -0,0 +1,92 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leader
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Checker", func() {
+	var (
+		client  crclient.Client
+		checker *Checker
+		req     *http.Request
+		lock    *corev1.ConfigMap
+	)
+	BeforeEach(func() {
+		os.Setenv("POD_NAME", "leader-test")
+		readNamespace = func() (string, error) {
+			return "testns", nil
+		}
+		client = fake.NewClientBuilder().WithObjects(
+			&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "leader-test",
+					Namespace: "testns",
+					UID:       "leader-uid",
+				},
+			},
+		).Build()
+		checker = NewChecker()
+		req = httptest.NewRequest(http.MethodGet, "/healthz", nil)
+		lock = &corev1.ConfigMap{}
+	})
+
+	Describe("before becoming the leader", func() {
+		It("should be alive", func() {
+			Expect(checker.IsLeader()).To(BeFalse())
+			Expect(checker.Liveness(req)).To(Succeed())
+		})
+		It("should not be ready", func() {
+			Expect(errors.Is(checker.Readiness(req), ErrNotLeader)).To(BeTrue())
+		})
+	})
+
+	Describe("after becoming the leader", func() {
+		BeforeEach(func() {
+			err := Become(context.TODO(), "leader-test", WithClient(client), WithChecker(checker))
+			Expect(err).Should(BeNil())
+			Expect(client.Get(context.TODO(), crclient.ObjectKey{Namespace: "testns", Name: "leader-test"}, lock)).To(Succeed())
+		})
+		It("should be alive and ready while the lock is held", func() {
+			Expect(checker.IsLeader()).To(BeTrue())
+			Expect(checker.Liveness(req)).To(Succeed())
+			Expect(checker.Readiness(req)).To(Succeed())
+		})
+		It("should fail both checks if the lock is deleted", func() {
+			Expect(client.Delete(context.TODO(), lock)).To(Succeed())
+			Expect(errors.Is(checker.Liveness(req), ErrLockLost)).To(BeTrue())
+			Expect(errors.Is(checker.Readiness(req), ErrLockLost)).To(BeTrue())
+		})
+		It("should fail both checks if the lock is owned by another pod", func() {
+			lock.OwnerReferences[0].Name = "other"
+			lock.OwnerReferences[0].UID = "other-uid"
+			Expect(client.Update(context.TODO(), lock)).To(Succeed())
+			Expect(errors.Is(checker.Liveness(req), ErrLockLost)).To(BeTrue())
+			Expect(errors.Is(checker.Readiness(req), ErrLockLost)).To(BeTrue())
+		})
+	})
+})
@@ -0,0 +1,134 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leader
+
+import (
+	"context"
+	"errors"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// LockLabel is set to "true" on every lock created by this package, so that
+// locks can be found by ListLocks. Locks created by earlier versions of this
+// package do not have it.
+const LockLabel = "operator-lib/leader-lock"
+
+func lockLabels() map[string]string {
+	return map[string]string{LockLabel: "true"}
+}
+
+// LockState describes whether a lock still blocks elections legitimately.
+type LockState string
+
+const (
+	// LockHeld indicates that the lock is held by an existing leader.
+	LockHeld LockState = "Held"
+	// LockOrphaned indicates that the pod owning the lock no longer exists,
+	// e.g. because the garbage collector has not deleted the lock yet.
+	LockOrphaned LockState = "Orphaned"
+	// LockExpired indicates that the holder of a lock taken off-cluster
+	// stopped renewing it.
+	LockExpired LockState = "Expired"
+	// LockInvalid indicates that the lock is neither owned by exactly one pod
+	// nor held off-cluster.
+	LockInvalid LockState = "Invalid"
+)
+
+// LockInfo describes a lock found by ListLocks.
+type LockInfo struct {
+	// Namespace, Name and UID identify the lock.
+	Namespace string
+	Name      string
+	UID       types.UID
+	// State is the state of the lock.
+	State LockState
+	// Leader describes the holder of the lock. It is nil for invalid locks.
+	Leader *LeaderInfo
+
+	resourceVersion string
+}
+
+// ListLocks returns the locks in namespace that have LockLabel, along with
+// their state. The generation counters of locks are not locks, and are not
+// listed. See GenerationCounterLabel.
+func ListLocks(ctx context.Context, client crclient.Client, namespace string) ([]LockInfo, error) {
+	cms := &corev1.ConfigMapList{}
+	err := client.List(ctx, cms, crclient.InNamespace(namespace), crclient.MatchingLabels(lockLabels()))
+	if err != nil {
+		return nil, err
+	}
+
+	locks := make([]LockInfo, 0, len(cms.Items))
+	for i := range cms.Items {
+		lock := &cms.Items[i]
+		info := LockInfo{
+			Namespace:       lock.Namespace,
+			Name:            lock.Name,
+			UID:             lock.UID,
+			resourceVersion: lock.ResourceVersion,
+		}
+		leader, err := getLeaderInfo(ctx, client, lock)
+		switch {
+		case errors.Is(err, ErrInvalidLock):
+			info.State = LockInvalid
+		case err != nil:
+			return nil, err
+		case leader.Health == LeaderDeleted:
+			info.State, info.Leader = LockOrphaned, leader
+		case leader.Health == LeaderExpired:
+			info.State, info.Leader = LockExpired, leader
+		default:
+			info.State, info.Leader = LockHeld, leader
+		}
+		locks = append(locks, info)
+	}
+	return locks, nil
+}
+
+// RemoveOrphanedLocks deletes the locks in namespace listed by ListLocks as
+// orphaned or expired, and returns the locks it deleted. Each lock is deleted
+// with a precondition on its UID and resource version, so a lock that has been
+// taken over in the meantime is left alone.
+func RemoveOrphanedLocks(ctx context.Context, client crclient.Client, namespace string) ([]LockInfo, error) {
+	locks, err := ListLocks(ctx, client, namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []LockInfo
+	for _, lock := range locks {
+		if lock.State != LockOrphaned && lock.State != LockExpired {
+			continue
+		}
+		cm := &corev1.ConfigMap{}
+		cm.Namespace, cm.Name = lock.Namespace, lock.Name
+		uid, resourceVersion := lock.UID, lock.resourceVersion
+		err := client.Delete(ctx, cm, crclient.Preconditions{UID: &uid, ResourceVersion: &resourceVersion})
+		switch {
+		case apierrors.IsNotFound(err), apierrors.IsConflict(err):
+			log.Info("Lock changed before it could be removed.", "ConfigMap.Namespace", lock.Namespace, "ConfigMap.Name", lock.Name)
+			continue
+		case err != nil:
+			return removed, err
+		}
+		log.Info("Removed orphaned lock.", "ConfigMap.Namespace", lock.Namespace, "ConfigMap.Name", lock.Name, "state", lock.State)
+		removed = append(removed, lock)
+	}
+	return removed, nil
+}
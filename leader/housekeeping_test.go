@@ -0,0 +1,99 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leader
+
+import (
+	"context"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Lock housekeeping", func() {
+	var client crclient.Client
+	lock := func(name string, owner string, annotations map[string]string) *corev1.ConfigMap {
+		cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   "testns",
+			UID:         types.UID("uid-" + name),
+			Labels:      lockLabels(),
+			Annotations: annotations,
+		}}
+		if owner != "" {
+			cm.OwnerReferences = []metav1.OwnerReference{{APIVersion: "v1", Kind: "Pod", Name: owner, UID: "a"}}
+		}
+		return cm
+	}
+	BeforeEach(func() {
+		stale := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+		unlabeled := lock("unlabeled", "pod-gone", nil)
+		unlabeled.Labels = nil
+		client = fake.NewClientBuilder().WithObjects(
+			&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-a", Namespace: "testns", UID: "a"}},
+			lock("held", "pod-a", nil),
+			lock("orphaned", "pod-gone", nil),
+			lock("expired", "", map[string]string{HolderAnnotation: "laptop", HeartbeatAnnotation: stale}),
+			lock("invalid", "", nil),
+			unlabeled,
+		).Build()
+	})
+	exists := func(name string) bool {
+		err := client.Get(context.TODO(), crclient.ObjectKey{Namespace: "testns", Name: name}, &corev1.ConfigMap{})
+		return !apierrors.IsNotFound(err)
+	}
+
+	It("should label the locks it creates", func() {
+		readNamespace = func() (string, error) {
+			return "testns", nil
+		}
+		os.Setenv("POD_NAME", "pod-a")
+		Expect(Become(context.TODO(), "new-lock", WithClient(client))).To(Succeed())
+		cm := &corev1.ConfigMap{}
+		Expect(client.Get(context.TODO(), crclient.ObjectKey{Namespace: "testns", Name: "new-lock"}, cm)).To(Succeed())
+		Expect(cm.Labels).To(HaveKeyWithValue(LockLabel, "true"))
+	})
+	It("should list labeled locks with their state", func() {
+		locks, err := ListLocks(context.TODO(), client, "testns")
+		Expect(err).Should(BeNil())
+		states := map[string]LockState{}
+		for _, l := range locks {
+			states[l.Name] = l.State
+		}
+		Expect(states).To(Equal(map[string]LockState{
+			"held":     LockHeld,
+			"orphaned": LockOrphaned,
+			"expired":  LockExpired,
+			"invalid":  LockInvalid,
+		}))
+	})
+	It("should only remove orphaned and expired locks", func() {
+		removed, err := RemoveOrphanedLocks(context.TODO(), client, "testns")
+		Expect(err).Should(BeNil())
+		Expect(removed).To(HaveLen(2))
+		Expect(exists("orphaned")).To(BeFalse())
+		Expect(exists("expired")).To(BeFalse())
+		Expect(exists("held")).To(BeTrue())
+		Expect(exists("invalid")).To(BeTrue())
+		Expect(exists("unlabeled")).To(BeTrue())
+	})
+})
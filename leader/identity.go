@@ -0,0 +1,136 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leader
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WithIdentity returns an Option that sets a stable identity for the current
+// pod. The caller must ensure that at most one running pod has a given
+// identity at a time.
+//
+// By default a pod is only considered the holder of a lock if its UID matches
+// the lock's owner reference, so a pod recreated with the same name waits for
+// the garbage collector to remove the lock of its predecessor. With a stable
+// identity, a pod instead takes over the lock of a deleted pod with the same
+// identity.
+func WithIdentity(id string) Option {
+	return func(c *Config) error {
+		if id == "" {
+			return fmt.Errorf("identity must not be empty")
+		}
+		c.Identity = id
+		return nil
+	}
+}
+
+// WithStatefulSetIdentity returns an Option that uses the StatefulSet name and
+// ordinal of the current pod as its stable identity. Since a StatefulSet
+// never runs two pods with the same ordinal at once, a recreated pod can
+// safely take over the lock held by its predecessor. See WithIdentity.
+func WithStatefulSetIdentity() Option {
+	return func(c *Config) error {
+		c.StatefulSetIdentity = true
+		return nil
+	}
+}
+
+// resolveIdentity sets c.Identity from pod if StatefulSetIdentity is set.
+func (c *Config) resolveIdentity(pod *corev1.Pod) error {
+	if !c.StatefulSetIdentity {
+		return nil
+	}
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil || ref.Kind != "StatefulSet" {
+		return fmt.Errorf("pod %s is not managed by a StatefulSet", pod.Name)
+	}
+	ordinal, err := statefulSetOrdinal(ref.Name, pod.Name)
+	if err != nil {
+		return err
+	}
+	c.Identity = fmt.Sprintf("statefulset/%s/%d", ref.Name, ordinal)
+	return nil
+}
+
+// statefulSetOrdinal returns the ordinal of the pod named podName in the
+// StatefulSet named setName.
+func statefulSetOrdinal(setName, podName string) (int, error) {
+	suffix := strings.TrimPrefix(podName, setName+"-")
+	if suffix == podName {
+		return 0, fmt.Errorf("pod %s does not belong to StatefulSet %s", podName, setName)
+	}
+	ordinal, err := strconv.Atoi(suffix)
+	if err != nil || ordinal < 0 {
+		return 0, fmt.Errorf("pod %s does not have a StatefulSet ordinal", podName)
+	}
+	return ordinal, nil
+}
+
+// adoptLock transfers lock to pod if it is held by a deleted pod with the same
+// stable identity. It returns true if the lock was adopted, and false if it
+// was not eligible or was concurrently modified or deleted.
+func (c *Config) adoptLock(ctx context.Context, lock *corev1.ConfigMap, pod *corev1.Pod) (bool, error) {
+	if c.Identity == "" || lock.Data[holderIdentityKey] != c.Identity {
+		return false, nil
+	}
+	owners := lock.GetOwnerReferences()
+	if len(owners) != 1 || owners[0].Kind != "Pod" || owners[0].UID == pod.UID {
+		return false, nil
+	}
+	_, health, err := getLeaderPod(ctx, c.Client, lock.Namespace, owners[0])
+	if err != nil {
+		return false, err
+	}
+	if health != LeaderDeleted {
+		log.Info("Lock is held by a running pod with my identity. Waiting.", "LockOwner", owners[0].Name, "Identity", c.Identity)
+		return false, nil
+	}
+
+	log.Info("Taking over lock held by a previous pod with my identity.", "LockOwnerUID", owners[0].UID, "Identity", c.Identity)
+	return c.transferLock(ctx, lock, pod)
+}
+
+// transferLock makes pod the owner of lock. It returns false if the lock was
+// concurrently modified or deleted.
+func (c *Config) transferLock(ctx context.Context, lock *corev1.ConfigMap, pod *corev1.Pod) (bool, error) {
+	lock.OwnerReferences = []metav1.OwnerReference{*podOwnerRef(pod)}
+	if lock.Data == nil {
+		lock.Data = map[string]string{}
+	}
+	for k, v := range c.holderData(pod) {
+		lock.Data[k] = v
+	}
+	// the new holder records the next generation once it has the lock
+	delete(lock.Data, generationKey)
+	// The update is guarded by the lock's resourceVersion, so it fails if the
+	// garbage collector or another candidate got there first.
+	err := c.Client.Update(ctx, lock)
+	switch {
+	case apierrors.IsConflict(err) || apierrors.IsNotFound(err):
+		log.Info("Lock changed while taking it over.")
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return true, nil
+}
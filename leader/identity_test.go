@@ -0,0 +1,138 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leader
+
+import (
+	"context"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Leader identity", func() {
+	var (
+		myPod *corev1.Pod
+		lock  *corev1.ConfigMap
+	)
+	BeforeEach(func() {
+		os.Setenv("POD_NAME", "operator-0")
+		readNamespace = func() (string, error) {
+			return "testns", nil
+		}
+		controller := true
+		myPod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "operator-0",
+				Namespace: "testns",
+				UID:       "new-uid",
+				OwnerReferences: []metav1.OwnerReference{
+					{APIVersion: "apps/v1", Kind: "StatefulSet", Name: "operator", Controller: &controller},
+				},
+			},
+		}
+		lock = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "leader-test",
+				Namespace: "testns",
+				OwnerReferences: []metav1.OwnerReference{
+					{APIVersion: "v1", Kind: "Pod", Name: "operator-0", UID: "old-uid"},
+				},
+			},
+			Data: map[string]string{holderIdentityKey: "statefulset/operator/0"},
+		}
+	})
+	getLock := func(client crclient.Client) *corev1.ConfigMap {
+		cm := &corev1.ConfigMap{}
+		Expect(client.Get(context.TODO(), crclient.ObjectKey{Namespace: "testns", Name: "leader-test"}, cm)).To(Succeed())
+		return cm
+	}
+
+	It("should not treat a recreated pod with the same name as the leader", func() {
+		client := fake.NewClientBuilder().WithObjects(myPod, lock).Build()
+		ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+		defer cancel()
+		err := Become(ctx, "leader-test", WithClient(client))
+		Expect(err).To(Equal(context.DeadlineExceeded))
+		Expect(getLock(client).OwnerReferences[0].UID).To(BeEquivalentTo("old-uid"))
+	})
+	It("should take over the lock of a deleted pod with the same StatefulSet identity", func() {
+		client := fake.NewClientBuilder().WithObjects(myPod, lock).Build()
+		err := Become(context.TODO(), "leader-test", WithClient(client), WithStatefulSetIdentity())
+		Expect(err).Should(BeNil())
+		cm := getLock(client)
+		Expect(cm.OwnerReferences).To(HaveLen(1))
+		Expect(cm.OwnerReferences[0].UID).To(BeEquivalentTo("new-uid"))
+		Expect(cm.Data).To(HaveKeyWithValue(holderPodUIDKey, "new-uid"))
+	})
+	It("should not take over a lock held by a running pod with the same identity", func() {
+		other := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "testns", UID: "other-uid"},
+		}
+		lock.OwnerReferences[0].Name = "other"
+		lock.OwnerReferences[0].UID = "other-uid"
+		lock.Data[holderIdentityKey] = "my-id"
+		client := fake.NewClientBuilder().WithObjects(myPod, other, lock).Build()
+		ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+		defer cancel()
+		err := Become(ctx, "leader-test", WithClient(client), WithIdentity("my-id"))
+		Expect(err).To(Equal(context.DeadlineExceeded))
+		Expect(getLock(client).OwnerReferences[0].UID).To(BeEquivalentTo("other-uid"))
+	})
+	It("should not take over a lock with a different identity", func() {
+		client := fake.NewClientBuilder().WithObjects(myPod, lock).Build()
+		ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+		defer cancel()
+		err := Become(ctx, "leader-test", WithClient(client), WithIdentity("my-id"))
+		Expect(err).To(Equal(context.DeadlineExceeded))
+	})
+	It("should record the identity in a new lock", func() {
+		client := fake.NewClientBuilder().WithObjects(myPod).Build()
+		err := Become(context.TODO(), "leader-test", WithClient(client), WithIdentity("my-id"))
+		Expect(err).Should(BeNil())
+		Expect(getLock(client).Data).To(HaveKeyWithValue(holderIdentityKey, "my-id"))
+	})
+	It("should reject an empty identity", func() {
+		err := Become(context.TODO(), "leader-test", WithIdentity(""))
+		Expect(err).ShouldNot(BeNil())
+	})
+	It("should fail if the pod is not managed by a StatefulSet", func() {
+		myPod.OwnerReferences = nil
+		client := fake.NewClientBuilder().WithObjects(myPod).Build()
+		err := Become(context.TODO(), "leader-test", WithClient(client), WithStatefulSetIdentity())
+		Expect(err).ShouldNot(BeNil())
+	})
+
+	Describe("statefulSetOrdinal", func() {
+		It("should return the ordinal of the pod", func() {
+			ordinal, err := statefulSetOrdinal("my-operator", "my-operator-12")
+			Expect(err).Should(BeNil())
+			Expect(ordinal).To(Equal(12))
+		})
+		It("should return an error for pods of another StatefulSet", func() {
+			_, err := statefulSetOrdinal("my-operator", "other-0")
+			Expect(err).ShouldNot(BeNil())
+		})
+		It("should return an error for pods without an ordinal", func() {
+			_, err := statefulSetOrdinal("my-operator", "my-operator-abc")
+			Expect(err).ShouldNot(BeNil())
+		})
+	})
+})
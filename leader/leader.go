@@ -24,7 +24,9 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/clock"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
 	crclient "sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
@@ -52,11 +54,108 @@ type Option func(*Config) error
 // Config defines the configuration for Become
 type Config struct {
 	Client crclient.Client
+
+	// Checker, if set, is notified when this pod becomes the leader so that
+	// it can report on the state of the lock.
+	Checker *Checker
+
+	// LeaderLabelKey and LeaderLabelValue, if set, are applied as a label to
+	// the leader pod and removed from the other pods managed by the same
+	// controller.
+	LeaderLabelKey   string
+	LeaderLabelValue string
+
+	// LeaderCondition, if set, is a pod condition set to True on the leader
+	// pod and to False on the other pods managed by the same controller.
+	LeaderCondition corev1.PodConditionType
+
+	// OperatorVersion, if set, is recorded in the lock when this pod becomes
+	// the leader.
+	OperatorVersion string
+
+	// Observers are notified of the progress of the election.
+	Observers []Observer
+
+	// Identity, if set, is a stable identity of the current pod that is kept
+	// when the pod is recreated. A pod takes over a lock held by a deleted pod
+	// with the same identity instead of waiting for it to be garbage
+	// collected.
+	Identity string
+
+	// StatefulSetIdentity derives Identity from the pod's StatefulSet and
+	// ordinal. The pod must be managed by a StatefulSet.
+	StatefulSetIdentity bool
+
+	// VersionHandoff makes candidates replace leaders running an older
+	// OperatorVersion.
+	VersionHandoff bool
+
+	// Namespace, if set, is the namespace of the lock and of the current pod.
+	// It defaults to the namespace the operator is running in.
+	Namespace string
+
+	// PodName, if set, is the name of the current pod. It defaults to the
+	// value of the POD_NAME environment variable.
+	PodName string
+
+	// Clock is used to wait between attempts and to timestamp the lock. It
+	// defaults to the real clock.
+	Clock clock.Clock
+
+	// OffCluster makes Become run outside of a pod. See WithOffCluster.
+	OffCluster bool
+
+	// LockDir is the directory of file locks taken off-cluster when no
+	// cluster is configured.
+	LockDir string
+
+	// HeartbeatInterval is how often a lock taken off-cluster is renewed.
+	HeartbeatInterval time.Duration
+
+	// Recorder, if set, records Kubernetes events on the lock and the pods
+	// involved in leadership changes. See WithEventRecorder.
+	Recorder record.EventRecorder
+
+	// IsTransient decides which errors of API calls Become retries. It
+	// defaults to IsTransientError. See WithRetryClassifier.
+	IsTransient func(error) bool
+
+	// Preflight makes Become check its permissions before running. See
+	// WithPreflight.
+	Preflight bool
+
+	// disabled holds the features disabled by the preflight check because
+	// their permissions are missing.
+	disabled map[string]bool
+}
+
+// newConfig returns a Config with opts applied and defaults set.
+func newConfig(opts ...Option) (*Config, error) {
+	config := &Config{}
+
+	for _, opt := range opts {
+		if err := opt(config); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := config.validateVersion(); err != nil {
+		return nil, err
+	}
+
+	if err := config.setDefaults(); err != nil {
+		return nil, err
+	}
+	return config, nil
 }
 
 func (c *Config) setDefaults() error {
 	if c.Client == nil {
 		config, err := config.GetConfig()
+		if err != nil && c.OffCluster {
+			// fall back to a local file lock
+			return nil
+		}
 		if err != nil {
 			return err
 		}
@@ -70,6 +169,14 @@ func (c *Config) setDefaults() error {
 	return nil
 }
 
+// clock returns the configured Clock, or the real clock if none is set.
+func (c *Config) clock() clock.Clock {
+	if c.Clock == nil {
+		return clock.RealClock{}
+	}
+	return c.Clock
+}
+
 // WithClient returns an Option that sets the Client used by Become
 func WithClient(cl crclient.Client) Option {
 	return func(c *Config) error {
@@ -78,135 +185,316 @@ func WithClient(cl crclient.Client) Option {
 	}
 }
 
-// Become ensures that the current pod is the leader within its namespace. If
-// run outside a cluster, it will skip leader election and return nil. It
+// WithNamespace returns an Option that sets the namespace of the lock and of
+// the current pod, instead of reading it from the environment
+func WithNamespace(ns string) Option {
+	return func(c *Config) error {
+		c.Namespace = ns
+		return nil
+	}
+}
+
+// WithPodName returns an Option that sets the name of the current pod,
+// instead of reading it from the POD_NAME environment variable
+func WithPodName(name string) Option {
+	return func(c *Config) error {
+		c.PodName = name
+		return nil
+	}
+}
+
+// WithClock returns an Option that sets the Clock used by Become
+func WithClock(clk clock.Clock) Option {
+	return func(c *Config) error {
+		c.Clock = clk
+		return nil
+	}
+}
+
+// WithOperatorVersion returns an Option that records version in the lock
+// when the current pod becomes the leader
+func WithOperatorVersion(version string) Option {
+	return func(c *Config) error {
+		c.OperatorVersion = version
+		return nil
+	}
+}
+
+// Become ensures that the current pod is the leader within its namespace. To
+// run outside of a pod, e.g. during development, use WithOffCluster. It
 // continuously tries to create a ConfigMap with the provided name and the
 // current pod set as the owner reference. Only one can exist at a time with
 // the same name, so the pod that successfully creates the ConfigMap is the
 // leader. Upon termination of that pod, the garbage collector will delete the
 // ConfigMap, enabling a different pod to become the leader.
+//
+// API calls failing with transient errors, as classified by IsTransientError,
+// are retried with backoff until ctx is done. Other errors are returned.
 func Become(ctx context.Context, lockName string, opts ...Option) error {
-	log.Info("Trying to become the leader.")
+	_, err := Acquire(ctx, lockName, opts...)
+	return err
+}
 
-	config := Config{}
+// Acquire is like Become, but also returns a handle on the acquired
+// leadership, which carries the fencing token of the current leader.
+func Acquire(ctx context.Context, lockName string, opts ...Option) (*Leadership, error) {
+	log.Info("Trying to become the leader.")
 
-	for _, opt := range opts {
-		if err := opt(&config); err != nil {
-			return err
-		}
+	config, err := newConfig(opts...)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := config.setDefaults(); err != nil {
-		return err
+	var l *Leadership
+	if config.OffCluster {
+		l, err = config.becomeOffCluster(ctx, lockName)
+	} else {
+		l, err = config.become(ctx, lockName)
 	}
-
-	ns, err := readNamespace()
 	if err != nil {
-		return err
+		config.notify(Event{Type: EventError, LockName: lockName, Err: err})
+		return nil, err
 	}
+	return l, nil
+}
 
-	owner, err := myOwnerRef(ctx, config.Client, ns)
+func (c *Config) become(ctx context.Context, lockName string) (*Leadership, error) {
+	ns, err := c.namespace()
 	if err != nil {
+		return nil, err
+	}
+
+	if c.Preflight {
+		if err := c.preflight(ctx, c.permissions(ns)); err != nil {
+			return nil, err
+		}
+	}
+
+	var myPod *corev1.Pod
+	err = c.retryTransient(ctx, "get current pod", func() (err error) {
+		myPod, err = c.currentPod(ctx, ns)
 		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	owner := podOwnerRef(myPod)
+	if err := c.resolveIdentity(myPod); err != nil {
+		return nil, err
 	}
 
 	// check for existing lock from this pod, in case we got restarted
 	existing := &corev1.ConfigMap{}
 	key := crclient.ObjectKey{Namespace: ns, Name: lockName}
-	err = config.Client.Get(ctx, key, existing)
+	err = c.retryTransient(ctx, "get lock", func() error {
+		return c.Client.Get(ctx, key, existing)
+	})
 
 	switch {
 	case err == nil:
 		for _, existingOwner := range existing.GetOwnerReferences() {
-			if existingOwner.Name == owner.Name {
+			if existingOwner.Name == owner.Name && existingOwner.UID == owner.UID {
 				log.Info("Found existing lock with my name. I was likely restarted.")
 				log.Info("Continuing as the leader.")
-				return nil
+				return c.becameLeader(ctx, existing, owner)
+			}
+			if existingOwner.Name == owner.Name {
+				log.Info("Found existing lock held by a previous pod with my name.", "LockOwnerUID", existingOwner.UID)
 			}
 			log.Info("Found existing lock", "LockOwner", existingOwner.Name)
+			c.notify(Event{Type: EventLockFound, Namespace: ns, LockName: lockName, Leader: existingOwner.Name})
+		}
+		adopted, err := c.adoptLock(ctx, existing, myPod)
+		if err != nil {
+			return nil, err
+		}
+		if adopted {
+			return c.becameLeader(ctx, existing, owner)
 		}
 	case apierrors.IsNotFound(err):
 		log.Info("No pre-existing lock was found.")
 	default:
 		log.Error(err, "Unknown error trying to get ConfigMap")
-		return err
+		return nil, err
 	}
 
 	cm := &corev1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:            lockName,
 			Namespace:       ns,
+			Labels:          lockLabels(),
 			OwnerReferences: []metav1.OwnerReference{*owner},
 		},
+		Data: c.holderData(myPod),
 	}
 
 	// try to create a lock
 	backoff := time.Second
 	for {
-		err := config.Client.Create(ctx, cm)
+		err := c.Client.Create(ctx, cm)
 		switch {
 		case err == nil:
 			log.Info("Became the leader.")
-			return nil
+			return c.becameLeader(ctx, cm, owner)
 		case apierrors.IsAlreadyExists(err):
 			// refresh the lock so we use current leader
 			key := crclient.ObjectKey{Namespace: ns, Name: lockName}
-			if err := config.Client.Get(ctx, key, existing); err != nil {
-				log.Info("Leader lock configmap not found.")
-				continue // configmap got lost ... just wait a bit
-			}
-
-			existingOwners := existing.GetOwnerReferences()
+			err := c.Client.Get(ctx, key, existing)
 			switch {
-			case len(existingOwners) != 1:
-				log.Info("Leader lock configmap must have exactly one owner reference.", "ConfigMap", existing)
-			case existingOwners[0].Kind != "Pod":
-				log.Info("Leader lock configmap owner reference must be a pod.", "OwnerReference", existingOwners[0])
+			case apierrors.IsNotFound(err):
+				log.Info("Leader lock configmap not found.")
+				continue // configmap got lost ... try to create it again
+			case c.isTransient(err):
+				log.Info("Transient error getting the leader lock, retrying.", "error", err.Error())
+			case err != nil:
+				log.Error(err, "Unknown error trying to get ConfigMap")
+				return nil, err
 			default:
-				leaderPod := &corev1.Pod{}
-				key = crclient.ObjectKey{Namespace: ns, Name: existingOwners[0].Name}
-				err = config.Client.Get(ctx, key, leaderPod)
-				switch {
-				case apierrors.IsNotFound(err):
-					log.Info("Leader pod has been deleted, waiting for garbage collection to remove the lock.")
-				case err != nil:
-					return err
-				case isPodEvicted(*leaderPod) && leaderPod.GetDeletionTimestamp() == nil:
-					log.Info("Operator pod with leader lock has been evicted.", "leader", leaderPod.Name)
-					log.Info("Deleting evicted leader.")
-					// Pod may not delete immediately, continue with backoff
-					err := config.Client.Delete(ctx, leaderPod)
-					if err != nil {
-						log.Error(err, "Leader pod could not be deleted.")
-					}
-				case isNotReadyNode(ctx, config.Client, leaderPod.Spec.NodeName):
-					log.Info("the status of the node where operator pod with leader lock was running has been 'notReady'")
-					log.Info("Deleting the leader.")
-
-					//Mark the termainating status to the leaderPod and Delete the configmap lock
-					if err := deleteLeader(ctx, config.Client, leaderPod, existing); err != nil {
-						return err
-					}
-
-				default:
-					log.Info("Not the leader. Waiting.")
+				adopted, err := c.handleExistingLock(ctx, existing, myPod)
+				if err != nil {
+					return nil, err
 				}
-			}
-
-			select {
-			case <-time.After(wait.Jitter(backoff, .2)):
-				if backoff < maxBackoffInterval {
-					backoff *= 2
+				if adopted {
+					return c.becameLeader(ctx, existing, owner)
 				}
-				continue
-			case <-ctx.Done():
-				return ctx.Err()
 			}
+		case c.isTransient(err):
+			log.Info("Transient error creating ConfigMap, retrying.", "error", err.Error())
 		default:
 			log.Error(err, "Unknown error creating ConfigMap")
-			return err
+			return nil, err
+		}
+
+		select {
+		case <-c.clock().After(wait.Jitter(backoff, .2)):
+			if backoff < maxBackoffInterval {
+				backoff *= 2
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// handleExistingLock inspects a lock held by another pod or taken off-cluster,
+// and replaces the leader if it is deemed dead or runs an older operator
+// version. It returns true if the current pod took over the lock.
+func (c *Config) handleExistingLock(ctx context.Context, lock *corev1.ConfigMap, myPod *corev1.Pod) (bool, error) {
+	owners := lock.GetOwnerReferences()
+	switch {
+	case len(owners) == 0 && lock.Annotations[HolderAnnotation] != "":
+		// the lock was taken off-cluster, so there is no pod to check, and
+		// the garbage collector never removes it
+		if _, err := c.handleOffClusterLock(ctx, lock); err != nil {
+			return false, err
+		}
+	case len(owners) != 1:
+		log.Info("Leader lock configmap must have exactly one owner reference.", "ConfigMap", lock)
+		c.notify(Event{Type: EventError, Namespace: lock.Namespace, LockName: lock.Name,
+			Err: fmt.Errorf("%w: ConfigMap must have exactly one owner reference", ErrInvalidLock)})
+	case owners[0].Kind != "Pod":
+		log.Info("Leader lock configmap owner reference must be a pod.", "OwnerReference", owners[0])
+		c.notify(Event{Type: EventError, Namespace: lock.Namespace, LockName: lock.Name,
+			Err: fmt.Errorf("%w: ConfigMap owner reference must be a pod", ErrInvalidLock)})
+	default:
+		checkNode := !c.disabled[featureNodeTakeover]
+		leaderPod, health, err := getLeaderPodHealth(ctx, c.Client, lock.Namespace, owners[0], checkNode)
+		evt := Event{Namespace: lock.Namespace, LockName: lock.Name, Leader: owners[0].Name, Health: health}
+		switch {
+		case c.isTransient(err):
+			log.Info("Transient error getting the leader pod, retrying.", "error", err.Error())
+		case err != nil:
+			return false, err
+		case health == LeaderDeleted:
+			evt.Type = EventLeaderDead
+			c.notify(evt)
+			adopted, err := c.adoptLock(ctx, lock, myPod)
+			if err != nil {
+				return false, err
+			}
+			if adopted {
+				return true, nil
+			}
+			log.Info("Leader pod has been deleted, waiting for garbage collection to remove the lock.")
+		case health == LeaderEvicted && c.disabled[featureEviction]:
+			log.Info("Leader has been evicted, but this pod is not allowed to delete it. Waiting.", "leader", leaderPod.Name)
+			evt.Type = EventWaiting
+			c.notify(evt)
+		case health == LeaderEvicted:
+			log.Info("Operator pod with leader lock has been evicted.", "leader", leaderPod.Name)
+			log.Info("Deleting evicted leader.")
+			evt.Type = EventEvictingLeader
+			c.notify(evt)
+			// Pod may not delete immediately, continue with backoff
+			err := c.Client.Delete(ctx, leaderPod)
+			if err != nil {
+				log.Error(err, "Leader pod could not be deleted.")
+				evt.Type, evt.Err = EventError, err
+				c.notify(evt)
+			} else {
+				c.recordTakeover(lock, leaderPod, ReasonEvictedLeaderDeleted,
+					fmt.Sprintf("Deleted evicted leader pod %s", leaderPod.Name))
+			}
+		case health == LeaderNodeNotReady:
+			log.Info("the status of the node where operator pod with leader lock was running has been 'notReady'")
+			log.Info("Deleting the leader.")
+			evt.Type = EventLeaderDead
+			c.notify(evt)
+
+			//Mark the termainating status to the leaderPod and Delete the configmap lock
+			if err := deleteLeader(ctx, c.Client, leaderPod, lock); err != nil {
+				return false, err
+			}
+			c.recordTakeover(lock, leaderPod, ReasonNotReadyLeaderReplaced,
+				fmt.Sprintf("Deleted leader pod %s and its lock as node %s is not ready", leaderPod.Name, leaderPod.Spec.NodeName))
+
+		case c.isOlderLeader(lock):
+			evt.Type = EventReplacingLeader
+			c.notify(evt)
+			if err := c.replaceOlderLeader(ctx, leaderPod, lock); err != nil {
+				return false, err
+			}
+		default:
+			log.Info("Not the leader. Waiting.")
+			evt.Type = EventWaiting
+			c.notify(evt)
 		}
 	}
+	return false, nil
+}
+
+// becameLeader records that lock is now held by owner, and returns the
+// resulting leadership.
+func (c *Config) becameLeader(ctx context.Context, lock *corev1.ConfigMap, owner *metav1.OwnerReference) (*Leadership, error) {
+	key := crclient.ObjectKey{Namespace: lock.Namespace, Name: lock.Name}
+	var generation int64
+	err := c.retryTransient(ctx, "record generation", func() (err error) {
+		generation, err = c.ensureGeneration(ctx, lock)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("became the leader but failed to record the fencing token: %w", err)
+	}
+	err = c.retryTransient(ctx, "mark leader pod", func() error {
+		return c.markLeader(ctx, key.Namespace, owner.Name)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("became the leader but failed to mark the leader pod: %w", err)
+	}
+	if c.Checker != nil {
+		c.Checker.setLeader(c.Client, key, owner)
+	}
+	c.notify(Event{Type: EventBecameLeader, Namespace: key.Namespace, LockName: key.Name, Leader: owner.Name})
+	c.recordBecameLeader(lock, owner)
+	return &Leadership{
+		Namespace:  key.Namespace,
+		LockName:   key.Name,
+		PodName:    owner.Name,
+		PodUID:     owner.UID,
+		Identity:   c.Identity,
+		Generation: generation,
+		client:     c.Client,
+	}, nil
 }
 
 // myOwnerRef returns an OwnerReference that corresponds to the pod in which
@@ -217,14 +505,65 @@ func myOwnerRef(ctx context.Context, client crclient.Client, ns string) (*metav1
 	if err != nil {
 		return nil, err
 	}
+	return podOwnerRef(myPod), nil
+}
 
-	owner := &metav1.OwnerReference{
+// podOwnerRef returns an OwnerReference to pod.
+func podOwnerRef(pod *corev1.Pod) *metav1.OwnerReference {
+	return &metav1.OwnerReference{
 		APIVersion: "v1",
 		Kind:       "Pod",
-		Name:       myPod.ObjectMeta.Name,
-		UID:        myPod.ObjectMeta.UID,
+		Name:       pod.ObjectMeta.Name,
+		UID:        pod.ObjectMeta.UID,
+	}
+}
+
+// holderData returns the lock data describing pod as the lock holder.
+func (c *Config) holderData(pod *corev1.Pod) map[string]string {
+	data := map[string]string{
+		holderPodKey:    pod.Name,
+		holderPodUIDKey: string(pod.UID),
+		acquiredAtKey:   c.clock().Now().UTC().Format(time.RFC3339),
+	}
+	if pod.Spec.NodeName != "" {
+		data[holderNodeKey] = pod.Spec.NodeName
 	}
-	return owner, nil
+	if c.OperatorVersion != "" {
+		data[operatorVersionKey] = c.OperatorVersion
+	}
+	if c.Identity != "" {
+		data[holderIdentityKey] = c.Identity
+	}
+	return data
+}
+
+// getLeaderPod returns the pod referenced by owner along with its health, as
+// judged by the criteria Become uses to decide whether to replace the leader.
+// If the pod no longer exists, the returned pod is nil.
+func getLeaderPod(ctx context.Context, client crclient.Client, ns string, owner metav1.OwnerReference) (*corev1.Pod, LeaderHealth, error) {
+	return getLeaderPodHealth(ctx, client, ns, owner, true)
+}
+
+// getLeaderPodHealth is like getLeaderPod, but only checks the leader's node
+// if checkNode is true.
+func getLeaderPodHealth(ctx context.Context, client crclient.Client, ns string, owner metav1.OwnerReference, checkNode bool) (*corev1.Pod, LeaderHealth, error) {
+	leaderPod := &corev1.Pod{}
+	key := crclient.ObjectKey{Namespace: ns, Name: owner.Name}
+	err := client.Get(ctx, key, leaderPod)
+	switch {
+	case apierrors.IsNotFound(err):
+		return nil, LeaderDeleted, nil
+	case err != nil:
+		return nil, "", err
+	case owner.UID != "" && leaderPod.UID != owner.UID:
+		// a pod with the same name replaced the leader
+		return nil, LeaderDeleted, nil
+	case isPodEvicted(*leaderPod) && leaderPod.GetDeletionTimestamp() == nil:
+		return leaderPod, LeaderEvicted, nil
+	case checkNode && isNotReadyNode(ctx, client, leaderPod.Spec.NodeName):
+		return leaderPod, LeaderNodeNotReady, nil
+	}
+	return leaderPod, LeaderHealthy, nil
 }
 
 func isPodEvicted(pod corev1.Pod) bool {
@@ -233,6 +572,24 @@ func isPodEvicted(pod corev1.Pod) bool {
 	return podFailed && podEvicted
 }
 
+// namespace returns the configured namespace, or the namespace the operator
+// is running in.
+func (c *Config) namespace() (string, error) {
+	if c.Namespace != "" {
+		return c.Namespace, nil
+	}
+	return readNamespace()
+}
+
+// currentPod returns the pod named by PodName, or the pod in which the code is
+// currently running if PodName is not set.
+func (c *Config) currentPod(ctx context.Context, ns string) (*corev1.Pod, error) {
+	if c.PodName != "" {
+		return getPodNamed(ctx, c.Client, ns, c.PodName)
+	}
+	return getPod(ctx, c.Client, ns)
+}
+
 // getPod returns a Pod object that corresponds to the pod in which the code
 // is currently running.
 // It expects the environment variable POD_NAME to be set by the downwards API.
@@ -244,6 +601,11 @@ func getPod(ctx context.Context, client crclient.Client, ns string) (*corev1.Pod
 
 	log.V(1).Info("Found podname", "Pod.Name", podName)
 
+	return getPodNamed(ctx, client, ns, podName)
+}
+
+// getPodNamed returns the Pod named podName in ns.
+func getPodNamed(ctx context.Context, client crclient.Client, ns, podName string) (*corev1.Pod, error) {
 	pod := &corev1.Pod{}
 	key := crclient.ObjectKey{Namespace: ns, Name: podName}
 	err := client.Get(ctx, key, pod)
@@ -87,6 +87,15 @@ var _ = Describe("Leader election", func() {
 			err := Become(context.TODO(), "leader-test", WithClient(client))
 			Expect(err).Should(BeNil())
 		})
+		It("should use the configured namespace and pod name", func() {
+			os.Unsetenv("POD_NAME")
+			readNamespace = func() (string, error) {
+				return "", ErrNoNamespace
+			}
+			err := Become(context.TODO(), "leader-test", WithClient(client),
+				WithNamespace("testns"), WithPodName("leader-test"))
+			Expect(err).Should(BeNil())
+		})
 	})
 	Describe("isPodEvicted", func() {
 		var (
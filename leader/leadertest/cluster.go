@@ -0,0 +1,264 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package leadertest simulates a cluster in which several candidate pods run
+// leader election, so that failover can be tested deterministically.
+//
+// A Cluster is backed by a fake client and a fake clock. Candidates are pods
+// created in the cluster, and their elections use the cluster's client and
+// clock. Nodes can be made NotReady, pods can be evicted or deleted, and the
+// garbage collection of locks owned by deleted pods can be run explicitly or
+// whenever the clock is stepped.
+package leadertest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/operator-framework/operator-lib/leader"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/clock"
+	"k8s.io/apimachinery/pkg/util/wait"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// waiterPollInterval is how often Step checks whether a candidate is waiting
+// on the clock.
+const waiterPollInterval = 10 * time.Millisecond
+
+// Cluster simulates the parts of a cluster that leader election depends on.
+type Cluster struct {
+	// Namespace is the namespace of the candidates and their locks.
+	Namespace string
+	// Client is the fake client backing the cluster. Objects may be created
+	// or modified through it directly.
+	Client crclient.Client
+	// Clock is the clock used by the candidates' elections.
+	Clock *clock.FakeClock
+
+	mu      sync.Mutex
+	gc      bool
+	nextUID int
+}
+
+// NewCluster returns a Cluster in namespace, initially containing objs.
+// Garbage collection is enabled.
+func NewCluster(namespace string, objs ...crclient.Object) *Cluster {
+	return &Cluster{
+		Namespace: namespace,
+		Client:    fake.NewClientBuilder().WithObjects(objs...).Build(),
+		Clock:     clock.NewFakeClock(time.Now()),
+		gc:        true,
+	}
+}
+
+// SetGarbageCollection enables or disables the garbage collection run by
+// DeletePod and Step. CollectGarbage always runs it.
+func (c *Cluster) SetGarbageCollection(enabled bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gc = enabled
+}
+
+func (c *Cluster) gcEnabled() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gc
+}
+
+func (c *Cluster) newUID(name string) types.UID {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextUID++
+	return types.UID(fmt.Sprintf("%s-%d", name, c.nextUID))
+}
+
+// AddNode creates a Ready node.
+func (c *Cluster) AddNode(ctx context.Context, name string) error {
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: name, UID: c.newUID(name)},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+		},
+	}
+	return c.Client.Create(ctx, node)
+}
+
+// SetNodeReady sets the Ready condition of the node.
+func (c *Cluster) SetNodeReady(ctx context.Context, name string, ready bool) error {
+	node := &corev1.Node{}
+	if err := c.Client.Get(ctx, crclient.ObjectKey{Name: name}, node); err != nil {
+		return err
+	}
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	node.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: status}}
+	return c.Client.Status().Update(ctx, node)
+}
+
+// AddCandidate creates a running pod on node, and returns a Candidate that
+// runs elections as that pod. node may be empty.
+func (c *Cluster) AddCandidate(ctx context.Context, name, node string) (*Candidate, error) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: c.Namespace, UID: c.newUID(name)},
+		Spec:       corev1.PodSpec{NodeName: node},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+	if err := c.Client.Create(ctx, pod); err != nil {
+		return nil, err
+	}
+	return &Candidate{Name: name, UID: pod.UID, cluster: c}, nil
+}
+
+// Evict marks the pod as evicted, as the kubelet does when it evicts a pod.
+func (c *Cluster) Evict(ctx context.Context, podName string) error {
+	pod := &corev1.Pod{}
+	if err := c.Client.Get(ctx, crclient.ObjectKey{Namespace: c.Namespace, Name: podName}, pod); err != nil {
+		return err
+	}
+	pod.Status.Phase = corev1.PodFailed
+	pod.Status.Reason = "Evicted"
+	return c.Client.Status().Update(ctx, pod)
+}
+
+// DeletePod deletes the pod, then collects garbage if garbage collection is
+// enabled.
+func (c *Cluster) DeletePod(ctx context.Context, podName string) error {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: podName, Namespace: c.Namespace}}
+	if err := c.Client.Delete(ctx, pod); err != nil {
+		return err
+	}
+	if c.gcEnabled() {
+		return c.CollectGarbage(ctx)
+	}
+	return nil
+}
+
+// CollectGarbage deletes the ConfigMaps in the namespace whose pod owners no
+// longer exist, as the garbage collector does. A ConfigMap is only deleted
+// once all of its owners are gone; owners other than pods are assumed to
+// exist.
+func (c *Cluster) CollectGarbage(ctx context.Context) error {
+	cms := &corev1.ConfigMapList{}
+	if err := c.Client.List(ctx, cms, crclient.InNamespace(c.Namespace)); err != nil {
+		return err
+	}
+	for i := range cms.Items {
+		cm := &cms.Items[i]
+		owners := cm.GetOwnerReferences()
+		if len(owners) == 0 {
+			continue
+		}
+		orphaned := true
+		for _, owner := range owners {
+			exists, err := c.podExists(ctx, owner)
+			if err != nil {
+				return err
+			}
+			if exists {
+				orphaned = false
+				break
+			}
+		}
+		if !orphaned {
+			continue
+		}
+		if err := c.Client.Delete(ctx, cm); err != nil && !apierrors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Cluster) podExists(ctx context.Context, owner metav1.OwnerReference) (bool, error) {
+	if owner.Kind != "Pod" {
+		return true, nil
+	}
+	pod := &corev1.Pod{}
+	err := c.Client.Get(ctx, crclient.ObjectKey{Namespace: c.Namespace, Name: owner.Name}, pod)
+	switch {
+	case apierrors.IsNotFound(err):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return pod.UID == owner.UID, nil
+}
+
+// Leader returns the current holder of lockName.
+func (c *Cluster) Leader(ctx context.Context, lockName string) (*leader.LeaderInfo, error) {
+	return leader.GetLeader(ctx, c.Client, c.Namespace, lockName)
+}
+
+// Step waits until a candidate is waiting on the clock, collects garbage if
+// garbage collection is enabled, and advances the clock by d. It returns an
+// error if ctx is done before any candidate waits.
+func (c *Cluster) Step(ctx context.Context, d time.Duration) error {
+	err := wait.PollImmediateUntil(waiterPollInterval, func() (bool, error) {
+		return c.Clock.HasWaiters(), nil
+	}, ctx.Done())
+	if err != nil {
+		return fmt.Errorf("no candidate is waiting on the clock: %w", err)
+	}
+	if c.gcEnabled() {
+		if err := c.CollectGarbage(ctx); err != nil {
+			return err
+		}
+	}
+	c.Clock.Step(d)
+	return nil
+}
+
+// Candidate is a pod of the Cluster that runs elections.
+type Candidate struct {
+	// Name and UID identify the candidate's pod.
+	Name string
+	UID  types.UID
+
+	cluster *Cluster
+}
+
+// Options returns the options that make an election run as the candidate in
+// its cluster, followed by opts.
+func (c *Candidate) Options(opts ...leader.Option) []leader.Option {
+	return append([]leader.Option{
+		leader.WithClient(c.cluster.Client),
+		leader.WithNamespace(c.cluster.Namespace),
+		leader.WithPodName(c.Name),
+		leader.WithClock(c.cluster.Clock),
+	}, opts...)
+}
+
+// Become runs leader.Become for lockName as the candidate.
+func (c *Candidate) Become(ctx context.Context, lockName string, opts ...leader.Option) error {
+	return leader.Become(ctx, lockName, c.Options(opts...)...)
+}
+
+// Start runs Become in a goroutine, and returns a channel that receives its
+// result.
+func (c *Candidate) Start(ctx context.Context, lockName string, opts ...leader.Option) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		result <- c.Become(ctx, lockName, opts...)
+	}()
+	return result
+}
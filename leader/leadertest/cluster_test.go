@@ -0,0 +1,120 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leadertest
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Cluster", func() {
+	const lockName = "leader-test"
+	var (
+		ctx     context.Context
+		cancel  context.CancelFunc
+		cluster *Cluster
+		first   *Candidate
+		second  *Candidate
+	)
+	// failover steps the clock until the election started by second returns.
+	failover := func(result <-chan error) error {
+		for {
+			select {
+			case err := <-result:
+				return err
+			default:
+			}
+			stepCtx, stepCancel := context.WithTimeout(ctx, time.Second)
+			err := cluster.Step(stepCtx, 20*time.Second)
+			stepCancel()
+			if err != nil {
+				return <-result
+			}
+		}
+	}
+	BeforeEach(func() {
+		var err error
+		ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		cluster = NewCluster("testns")
+		Expect(cluster.AddNode(ctx, "node-1")).To(Succeed())
+		Expect(cluster.AddNode(ctx, "node-2")).To(Succeed())
+		first, err = cluster.AddCandidate(ctx, "pod-1", "node-1")
+		Expect(err).Should(BeNil())
+		second, err = cluster.AddCandidate(ctx, "pod-2", "node-2")
+		Expect(err).Should(BeNil())
+		Expect(first.Become(ctx, lockName)).To(Succeed())
+	})
+	AfterEach(func() {
+		cancel()
+	})
+
+	It("should elect the first candidate", func() {
+		info, err := cluster.Leader(ctx, lockName)
+		Expect(err).Should(BeNil())
+		Expect(info.PodName).To(Equal("pod-1"))
+		Expect(info.PodUID).To(Equal(first.UID))
+	})
+	It("should keep a candidate waiting while the leader is healthy", func() {
+		result := second.Start(ctx, lockName)
+		for i := 0; i < 3; i++ {
+			Expect(cluster.Step(ctx, 20*time.Second)).To(Succeed())
+		}
+		Consistently(result).ShouldNot(Receive())
+	})
+	It("should fail over once the leader pod is deleted", func() {
+		result := second.Start(ctx, lockName)
+		Expect(cluster.DeletePod(ctx, first.Name)).To(Succeed())
+		Expect(failover(result)).To(Succeed())
+
+		info, err := cluster.Leader(ctx, lockName)
+		Expect(err).Should(BeNil())
+		Expect(info.PodName).To(Equal("pod-2"))
+	})
+	It("should wait for garbage collection of a deleted leader's lock", func() {
+		cluster.SetGarbageCollection(false)
+		result := second.Start(ctx, lockName)
+		Expect(cluster.DeletePod(ctx, first.Name)).To(Succeed())
+		for i := 0; i < 3; i++ {
+			Expect(cluster.Step(ctx, 20*time.Second)).To(Succeed())
+		}
+		Consistently(result).ShouldNot(Receive())
+
+		Expect(cluster.CollectGarbage(ctx)).To(Succeed())
+		Expect(failover(result)).To(Succeed())
+	})
+	It("should fail over once the leader's node is not ready", func() {
+		result := second.Start(ctx, lockName)
+		Expect(cluster.SetNodeReady(ctx, "node-1", false)).To(Succeed())
+		Expect(failover(result)).To(Succeed())
+
+		err := cluster.Client.Get(ctx, crclient.ObjectKey{Namespace: "testns", Name: "pod-1"}, &corev1.Pod{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	})
+	It("should fail over once the leader is evicted", func() {
+		result := second.Start(ctx, lockName)
+		Expect(cluster.Evict(ctx, first.Name)).To(Succeed())
+		Expect(failover(result)).To(Succeed())
+
+		info, err := cluster.Leader(ctx, lockName)
+		Expect(err).Should(BeNil())
+		Expect(info.PodName).To(Equal("pod-2"))
+	})
+})
@@ -0,0 +1,159 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leader
+
+import (
+	"context"
+
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// Elector is a manager.Runnable that runs Become when the manager starts. It
+// does not itself need leader election, so it starts alongside the manager's
+// other non-leader-election runnables such as health probes, metrics and
+// webhooks. Runnables wrapped with Gate only start once Become has returned
+// successfully.
+type Elector struct {
+	lockName   string
+	opts       []Option
+	elected    chan struct{}
+	leadership *Leadership
+}
+
+var (
+	_ manager.Runnable               = &Elector{}
+	_ manager.LeaderElectionRunnable = &Elector{}
+)
+
+// NewElector returns an Elector that calls Become with lockName and opts.
+func NewElector(lockName string, opts ...Option) *Elector {
+	return &Elector{
+		lockName: lockName,
+		opts:     opts,
+		elected:  make(chan struct{}),
+	}
+}
+
+// Start implements manager.Runnable. It blocks until ctx is done, and returns
+// an error if Become fails for any other reason than ctx being done, e.g. the
+// manager stopping while the current pod waits to become the leader.
+func (e *Elector) Start(ctx context.Context) error {
+	l, err := Acquire(ctx, e.lockName, e.opts...)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	e.leadership = l
+	close(e.elected)
+	<-ctx.Done()
+	return nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable.
+func (e *Elector) NeedLeaderElection() bool {
+	return false
+}
+
+// Elected returns a channel that is closed once the current pod becomes the
+// leader.
+func (e *Elector) Elected() <-chan struct{} {
+	return e.elected
+}
+
+// Leadership returns the leadership acquired by the Elector, or nil if the
+// current pod has not become the leader yet.
+func (e *Elector) Leadership() *Leadership {
+	select {
+	case <-e.elected:
+		return e.leadership
+	default:
+		return nil
+	}
+}
+
+// Gate returns a Runnable that starts r once the current pod becomes the
+// leader. The returned Runnable does not need leader election.
+func (e *Elector) Gate(r manager.Runnable) manager.Runnable {
+	return &gatedRunnable{Runnable: r, elected: e.elected}
+}
+
+type gatedRunnable struct {
+	manager.Runnable
+	elected <-chan struct{}
+}
+
+func (r *gatedRunnable) Start(ctx context.Context) error {
+	select {
+	case <-r.elected:
+		return r.Runnable.Start(ctx)
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+func (r *gatedRunnable) NeedLeaderElection() bool {
+	return false
+}
+
+// WrapManager adds an Elector for lockName to mgr, and returns a Manager that
+// gates every runnable needing leader election on the Elector. Runnables that
+// do not need leader election, such as health probes, metrics and webhooks,
+// start immediately. The Elected channel of the returned Manager is closed
+// once the current pod becomes the leader. Controllers should be registered
+// with the returned Manager, and mgr must be created with LeaderElection
+// disabled:
+//
+//	mgr, err := ctrl.NewManager(cfg, ctrl.Options{LeaderElection: false})
+//	...
+//	mgr, err = leader.WrapManager(mgr, "my-operator-lock")
+//	...
+//	err = ctrl.NewControllerManagedBy(mgr).For(&v1.Foo{}).Complete(r)
+func WrapManager(mgr manager.Manager, lockName string, opts ...Option) (manager.Manager, error) {
+	e := NewElector(lockName, opts...)
+	if err := mgr.Add(e); err != nil {
+		return nil, err
+	}
+	return &gatedManager{Manager: mgr, elector: e}, nil
+}
+
+type gatedManager struct {
+	manager.Manager
+	elector *Elector
+}
+
+// Add wraps r with the Elector's gate if r needs leader election.
+func (m *gatedManager) Add(r manager.Runnable) error {
+	if le, ok := r.(manager.LeaderElectionRunnable); ok && !le.NeedLeaderElection() {
+		return m.Manager.Add(r)
+	}
+	if _, ok := r.(interface{ GetCache() cache.Cache }); ok {
+		return m.Manager.Add(r)
+	}
+	// The gate hides r's injection interfaces from the manager, so inject
+	// its dependencies before wrapping it.
+	if err := m.Manager.SetFields(r); err != nil {
+		return err
+	}
+	return m.Manager.Add(m.elector.Gate(r))
+}
+
+// Elected returns the Elector's channel, which is closed once the current pod
+// becomes the leader, as the manager's own leader election is disabled.
+func (m *gatedManager) Elected() <-chan struct{} {
+	return m.elector.Elected()
+}
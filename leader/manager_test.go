@@ -0,0 +1,169 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leader
+
+import (
+	"context"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+type fakeManager struct {
+	manager.Manager
+	runnables []manager.Runnable
+	injected  []interface{}
+}
+
+func (m *fakeManager) Add(r manager.Runnable) error {
+	m.runnables = append(m.runnables, r)
+	return nil
+}
+
+func (m *fakeManager) SetFields(i interface{}) error {
+	m.injected = append(m.injected, i)
+	return nil
+}
+
+type fakeRunnable struct {
+	needLeaderElection bool
+	started            chan struct{}
+}
+
+func (r *fakeRunnable) Start(ctx context.Context) error {
+	close(r.started)
+	<-ctx.Done()
+	return nil
+}
+
+func (r *fakeRunnable) NeedLeaderElection() bool {
+	return r.needLeaderElection
+}
+
+var _ = Describe("Manager integration", func() {
+	var (
+		client crclient.Client
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	BeforeEach(func() {
+		os.Setenv("POD_NAME", "leader-test")
+		readNamespace = func() (string, error) {
+			return "testns", nil
+		}
+		client = fake.NewClientBuilder().WithObjects(
+			&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "leader-test",
+					Namespace: "testns",
+				},
+			},
+			&corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "leader-test",
+					Namespace: "testns",
+					OwnerReferences: []metav1.OwnerReference{
+						{APIVersion: "v1", Kind: "Pod", Name: "other"},
+					},
+				},
+			},
+		).Build()
+		ctx, cancel = context.WithCancel(context.TODO())
+	})
+	AfterEach(func() {
+		cancel()
+	})
+
+	Describe("Elector", func() {
+		It("should not need leader election", func() {
+			Expect(NewElector("leader-test").NeedLeaderElection()).To(BeFalse())
+		})
+		It("should only start gated runnables once elected", func() {
+			e := NewElector("leader-test", WithClient(client))
+			r := &fakeRunnable{started: make(chan struct{})}
+			gated := e.Gate(r)
+			Expect(gated.(manager.LeaderElectionRunnable).NeedLeaderElection()).To(BeFalse())
+
+			go func() { _ = gated.Start(ctx) }()
+			go func() { _ = e.Start(ctx) }()
+			Consistently(r.started).ShouldNot(BeClosed())
+
+			lock := &corev1.ConfigMap{}
+			Expect(client.Get(ctx, crclient.ObjectKey{Namespace: "testns", Name: "leader-test"}, lock)).To(Succeed())
+			Expect(client.Delete(ctx, lock)).To(Succeed())
+
+			Eventually(e.Elected(), "5s").Should(BeClosed())
+			Eventually(r.started).Should(BeClosed())
+		})
+		It("should stop without an error if the context is cancelled while waiting", func() {
+			e := NewElector("leader-test", WithClient(client))
+			done := make(chan error, 1)
+			go func() { done <- e.Start(ctx) }()
+			Consistently(done).ShouldNot(Receive())
+			cancel()
+			Eventually(done).Should(Receive(BeNil()))
+			Expect(e.Elected()).NotTo(BeClosed())
+		})
+		It("should return an error if Become fails", func() {
+			e := NewElector("leader-test", WithClient(client), WithLeaderLabel("not a key", "true"))
+			Expect(e.Start(ctx)).NotTo(Succeed())
+		})
+		It("should not start gated runnables if the context is cancelled", func() {
+			e := NewElector("leader-test", WithClient(client))
+			r := &fakeRunnable{started: make(chan struct{})}
+			cancel()
+			Expect(e.Gate(r).Start(ctx)).To(Succeed())
+			Expect(r.started).NotTo(BeClosed())
+		})
+	})
+
+	Describe("WrapManager", func() {
+		It("should gate only runnables that need leader election", func() {
+			fm := &fakeManager{}
+			mgr, err := WrapManager(fm, "leader-test", WithClient(client))
+			Expect(err).Should(BeNil())
+			Expect(fm.runnables).To(HaveLen(1))
+			Expect(fm.runnables[0]).To(BeAssignableToTypeOf(&Elector{}))
+
+			nonLeader := &fakeRunnable{needLeaderElection: false}
+			Expect(mgr.Add(nonLeader)).To(Succeed())
+			Expect(fm.runnables[1]).To(BeIdenticalTo(nonLeader))
+
+			leader := &fakeRunnable{needLeaderElection: true}
+			Expect(mgr.Add(leader)).To(Succeed())
+			Expect(fm.runnables[2]).To(BeAssignableToTypeOf(&gatedRunnable{}))
+			Expect(fm.injected).To(ContainElement(leader))
+		})
+		It("should only report being elected once Become succeeds", func() {
+			fm := &fakeManager{}
+			mgr, err := WrapManager(fm, "leader-test", WithClient(client))
+			Expect(err).Should(BeNil())
+
+			go func() { _ = fm.runnables[0].Start(ctx) }()
+			Consistently(mgr.Elected()).ShouldNot(BeClosed())
+
+			lock := &corev1.ConfigMap{}
+			Expect(client.Get(ctx, crclient.ObjectKey{Namespace: "testns", Name: "leader-test"}, lock)).To(Succeed())
+			Expect(client.Delete(ctx, lock)).To(Succeed())
+			Eventually(mgr.Elected(), "5s").Should(BeClosed())
+		})
+	})
+})
@@ -0,0 +1,171 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leader
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// LeaderLabel is the default label key used by WithLeaderLabel to mark the
+// leader pod.
+const LeaderLabel = "operator-lib/leader"
+
+// WithLeaderLabel returns an Option that labels the leader pod with key=value
+// once it becomes the leader, and removes the label from the other pods
+// managed by the same controller, e.g. ReplicaSet or StatefulSet. A Service
+// can then select only the leader, e.g.:
+//
+//	leader.WithLeaderLabel(leader.LeaderLabel, "true")
+//
+// This requires permission to list and patch pods.
+func WithLeaderLabel(key, value string) Option {
+	return func(c *Config) error {
+		if errs := validation.IsQualifiedName(key); len(errs) != 0 {
+			return fmt.Errorf("invalid leader label key %q: %v", key, errs)
+		}
+		if errs := validation.IsValidLabelValue(value); len(errs) != 0 {
+			return fmt.Errorf("invalid leader label value %q: %v", value, errs)
+		}
+		c.LeaderLabelKey = key
+		c.LeaderLabelValue = value
+		return nil
+	}
+}
+
+// WithLeaderCondition returns an Option that sets the pod condition condType
+// to True on the leader pod once it becomes the leader, and to False on the
+// other pods managed by the same controller that have it set to True. Listing
+// condType in the pod's spec.readinessGates makes only the leader pod Ready.
+// This requires permission to list pods and patch pods/status.
+func WithLeaderCondition(condType corev1.PodConditionType) Option {
+	return func(c *Config) error {
+		if errs := validation.IsQualifiedName(string(condType)); len(errs) != 0 {
+			return fmt.Errorf("invalid leader condition type %q: %v", condType, errs)
+		}
+		c.LeaderCondition = condType
+		return nil
+	}
+}
+
+// markLeader applies the configured leader label and condition to the pod
+// named leaderName, and removes them from the other candidates, i.e. the pods
+// managed by the same controller as the leader. Pods of other operators in the
+// namespace are left alone, even if they use the same label or condition.
+func (c *Config) markLeader(ctx context.Context, ns, leaderName string) error {
+	if c.LeaderLabelKey == "" && c.LeaderCondition == "" {
+		return nil
+	}
+
+	leader, err := getPodNamed(ctx, c.Client, ns, leaderName)
+	if err != nil {
+		return err
+	}
+	peers, err := listPeers(ctx, c.Client, leader)
+	if err != nil {
+		return err
+	}
+
+	// Unmark previous leaders first so there is never more than one pod
+	// marked as the leader.
+	for i := range peers {
+		if err := c.unmarkPod(ctx, &peers[i]); err != nil {
+			return err
+		}
+	}
+	return c.markPod(ctx, leader)
+}
+
+func (c *Config) markPod(ctx context.Context, pod *corev1.Pod) error {
+	if c.LeaderLabelKey != "" && pod.Labels[c.LeaderLabelKey] != c.LeaderLabelValue {
+		patch := crclient.MergeFrom(pod.DeepCopy())
+		if pod.Labels == nil {
+			pod.Labels = map[string]string{}
+		}
+		pod.Labels[c.LeaderLabelKey] = c.LeaderLabelValue
+		if err := c.Client.Patch(ctx, pod, patch); err != nil {
+			log.Error(err, "Failed to label leader pod", "Pod.Name", pod.Name)
+			return err
+		}
+		log.Info("Labeled leader pod.", "Pod.Name", pod.Name, "Label", c.LeaderLabelKey)
+	}
+	if c.LeaderCondition != "" {
+		if err := c.setPodCondition(ctx, pod, corev1.ConditionTrue, "BecameLeader"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Config) unmarkPod(ctx context.Context, pod *corev1.Pod) error {
+	if c.LeaderLabelKey != "" {
+		if _, ok := pod.Labels[c.LeaderLabelKey]; ok {
+			patch := crclient.MergeFrom(pod.DeepCopy())
+			delete(pod.Labels, c.LeaderLabelKey)
+			if err := c.Client.Patch(ctx, pod, patch); err != nil {
+				log.Error(err, "Failed to remove leader label from pod", "Pod.Name", pod.Name)
+				return err
+			}
+			log.Info("Removed leader label from previous leader.", "Pod.Name", pod.Name)
+		}
+	}
+	if c.LeaderCondition != "" {
+		if cond := getPodCondition(pod, c.LeaderCondition); cond != nil && cond.Status == corev1.ConditionTrue {
+			if err := c.setPodCondition(ctx, pod, corev1.ConditionFalse, "NotLeader"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Config) setPodCondition(ctx context.Context, pod *corev1.Pod, status corev1.ConditionStatus, reason string) error {
+	if cond := getPodCondition(pod, c.LeaderCondition); cond != nil && cond.Status == status {
+		return nil
+	}
+	patch := crclient.StrategicMergeFrom(pod.DeepCopy())
+	now := metav1.Now()
+	newCond := corev1.PodCondition{
+		Type:               c.LeaderCondition,
+		Status:             status,
+		Reason:             reason,
+		LastTransitionTime: now,
+		LastProbeTime:      now,
+	}
+	if cond := getPodCondition(pod, c.LeaderCondition); cond != nil {
+		*cond = newCond
+	} else {
+		pod.Status.Conditions = append(pod.Status.Conditions, newCond)
+	}
+	if err := c.Client.Status().Patch(ctx, pod, patch); err != nil {
+		log.Error(err, "Failed to set leader condition on pod", "Pod.Name", pod.Name, "Condition", c.LeaderCondition)
+		return err
+	}
+	return nil
+}
+
+func getPodCondition(pod *corev1.Pod, condType corev1.PodConditionType) *corev1.PodCondition {
+	for i := range pod.Status.Conditions {
+		if pod.Status.Conditions[i].Type == condType {
+			return &pod.Status.Conditions[i]
+		}
+	}
+	return nil
+}
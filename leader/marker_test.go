@@ -0,0 +1,127 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leader
+
+import (
+	"context"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Leader pod marking", func() {
+	const leaderCondition corev1.PodConditionType = "operator-lib/leader"
+	var (
+		client crclient.Client
+	)
+	getPod := func(name string) *corev1.Pod {
+		pod := &corev1.Pod{}
+		Expect(client.Get(context.TODO(), crclient.ObjectKey{Namespace: "testns", Name: name}, pod)).To(Succeed())
+		return pod
+	}
+	controller := []metav1.OwnerReference{{
+		APIVersion: "apps/v1",
+		Kind:       "ReplicaSet",
+		Name:       "operator",
+		UID:        "rs-uid",
+		Controller: &[]bool{true}[0],
+	}}
+	BeforeEach(func() {
+		os.Setenv("POD_NAME", "leader-test")
+		readNamespace = func() (string, error) {
+			return "testns", nil
+		}
+		client = fake.NewClientBuilder().WithObjects(
+			&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            "leader-test",
+					Namespace:       "testns",
+					OwnerReferences: controller,
+				},
+			},
+			&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            "old-leader",
+					Namespace:       "testns",
+					Labels:          map[string]string{LeaderLabel: "true", "app": "operator"},
+					OwnerReferences: controller,
+				},
+				Status: corev1.PodStatus{
+					Conditions: []corev1.PodCondition{
+						{Type: leaderCondition, Status: corev1.ConditionTrue},
+						{Type: corev1.PodReady, Status: corev1.ConditionTrue},
+					},
+				},
+			},
+		).Build()
+	})
+	It("should reject an invalid label key", func() {
+		err := Become(context.TODO(), "leader-test", WithClient(client), WithLeaderLabel("not a key", "true"))
+		Expect(err).ShouldNot(BeNil())
+	})
+	It("should reject an invalid condition type", func() {
+		err := Become(context.TODO(), "leader-test", WithClient(client), WithLeaderCondition("not a condition"))
+		Expect(err).ShouldNot(BeNil())
+	})
+	It("should move the leader label to the new leader", func() {
+		err := Become(context.TODO(), "leader-test", WithClient(client), WithLeaderLabel(LeaderLabel, "true"))
+		Expect(err).Should(BeNil())
+		Expect(getPod("leader-test").Labels).To(HaveKeyWithValue(LeaderLabel, "true"))
+		old := getPod("old-leader")
+		Expect(old.Labels).NotTo(HaveKey(LeaderLabel))
+		Expect(old.Labels).To(HaveKeyWithValue("app", "operator"))
+	})
+	It("should move the leader condition to the new leader", func() {
+		err := Become(context.TODO(), "leader-test", WithClient(client), WithLeaderCondition(leaderCondition))
+		Expect(err).Should(BeNil())
+
+		cond := getPodCondition(getPod("leader-test"), leaderCondition)
+		Expect(cond).NotTo(BeNil())
+		Expect(cond.Status).To(Equal(corev1.ConditionTrue))
+
+		old := getPod("old-leader")
+		cond = getPodCondition(old, leaderCondition)
+		Expect(cond).NotTo(BeNil())
+		Expect(cond.Status).To(Equal(corev1.ConditionFalse))
+		Expect(getPodCondition(old, corev1.PodReady)).NotTo(BeNil())
+	})
+	It("should not unmark pods of other controllers", func() {
+		other := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "other-operator",
+				Namespace: "testns",
+				Labels:    map[string]string{LeaderLabel: "true"},
+				OwnerReferences: []metav1.OwnerReference{{
+					APIVersion: "apps/v1",
+					Kind:       "ReplicaSet",
+					Name:       "other-operator",
+					UID:        "other-rs-uid",
+					Controller: &[]bool{true}[0],
+				}},
+			},
+		}
+		Expect(client.Create(context.TODO(), other)).To(Succeed())
+
+		err := Become(context.TODO(), "leader-test", WithClient(client), WithLeaderLabel(LeaderLabel, "true"))
+		Expect(err).Should(BeNil())
+		Expect(getPod("old-leader").Labels).NotTo(HaveKey(LeaderLabel))
+		Expect(getPod("other-operator").Labels).To(HaveKeyWithValue(LeaderLabel, "true"))
+	})
+})
@@ -0,0 +1,90 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leader
+
+// EventType is the type of an election Event.
+type EventType string
+
+const (
+	// EventLockFound is emitted when Become finds a lock held by another pod
+	// on startup.
+	EventLockFound EventType = "LockFound"
+	// EventWaiting is emitted each time Become finds a healthy leader and
+	// waits before trying again.
+	EventWaiting EventType = "Waiting"
+	// EventLeaderDead is emitted when the leader pod is deemed dead, either
+	// because it no longer exists or because its node is not ready. The
+	// Health field of the Event tells which.
+	EventLeaderDead EventType = "LeaderDead"
+	// EventEvictingLeader is emitted when Become deletes an evicted leader.
+	EventEvictingLeader EventType = "EvictingLeader"
+	// EventReplacingLeader is emitted when Become replaces a healthy leader
+	// running an older operator version. See WithVersionHandoff.
+	EventReplacingLeader EventType = "ReplacingLeader"
+	// EventBecameLeader is emitted when the current pod becomes the leader,
+	// or finds that it already is.
+	EventBecameLeader EventType = "BecameLeader"
+	// EventError is emitted when Become encounters an error. Become keeps
+	// trying after some errors, such as an invalid lock; it returns every
+	// other error after emitting it.
+	EventError EventType = "Error"
+)
+
+// Event describes the progress of an election.
+type Event struct {
+	Type EventType
+	// Namespace and LockName identify the lock. Namespace is empty if the
+	// error occurred before the namespace was known.
+	Namespace string
+	LockName  string
+	// Leader is the name of the leader pod the event relates to, if any.
+	Leader string
+	// Health is the health of Leader, if it was checked.
+	Health LeaderHealth
+	// Err is set for EventError.
+	Err error
+}
+
+// Observer is notified of election events. Observe is mostly called
+// synchronously from Become, so it must not block. Off-cluster, it is also
+// called with ErrLockLost if the lock is lost after Become has returned, from
+// another goroutine and possibly concurrently with other calls, so it must be
+// safe for concurrent use.
+type Observer interface {
+	Observe(Event)
+}
+
+// ObserverFunc is a function that implements Observer.
+type ObserverFunc func(Event)
+
+// Observe implements Observer.
+func (f ObserverFunc) Observe(e Event) {
+	f(e)
+}
+
+// WithObserver returns an Option that adds o to the observers notified by
+// Become. It may be given more than once.
+func WithObserver(o Observer) Option {
+	return func(c *Config) error {
+		c.Observers = append(c.Observers, o)
+		return nil
+	}
+}
+
+func (c *Config) notify(e Event) {
+	for _, o := range c.Observers {
+		o.Observe(e)
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leader
+
+import (
+	"context"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Observers", func() {
+	var (
+		events   []Event
+		observer Observer
+		myPod    *corev1.Pod
+	)
+	eventTypes := func() []EventType {
+		types := []EventType{}
+		for _, e := range events {
+			types = append(types, e.Type)
+		}
+		return types
+	}
+	lockHeldBy := func(name string) *corev1.ConfigMap {
+		return &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "leader-test",
+				Namespace: "testns",
+				OwnerReferences: []metav1.OwnerReference{
+					{APIVersion: "v1", Kind: "Pod", Name: name},
+				},
+			},
+		}
+	}
+	BeforeEach(func() {
+		os.Setenv("POD_NAME", "leader-test")
+		readNamespace = func() (string, error) {
+			return "testns", nil
+		}
+		events = nil
+		observer = ObserverFunc(func(e Event) {
+			events = append(events, e)
+		})
+		myPod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "leader-test",
+				Namespace: "testns",
+			},
+		}
+	})
+
+	It("should be notified when becoming the leader", func() {
+		client := fake.NewClientBuilder().WithObjects(myPod).Build()
+		err := Become(context.TODO(), "leader-test", WithClient(client), WithObserver(observer))
+		Expect(err).Should(BeNil())
+		Expect(eventTypes()).To(Equal([]EventType{EventBecameLeader}))
+		Expect(events[0].Namespace).To(Equal("testns"))
+		Expect(events[0].LockName).To(Equal("leader-test"))
+		Expect(events[0].Leader).To(Equal("leader-test"))
+	})
+	It("should be notified when continuing as the leader", func() {
+		client := fake.NewClientBuilder().WithObjects(myPod, lockHeldBy("leader-test")).Build()
+		err := Become(context.TODO(), "leader-test", WithClient(client), WithObserver(observer))
+		Expect(err).Should(BeNil())
+		Expect(eventTypes()).To(Equal([]EventType{EventBecameLeader}))
+	})
+	It("should be notified of errors", func() {
+		os.Unsetenv("POD_NAME")
+		client := fake.NewClientBuilder().WithObjects(myPod).Build()
+		err := Become(context.TODO(), "leader-test", WithClient(client), WithObserver(observer))
+		Expect(err).ShouldNot(BeNil())
+		Expect(eventTypes()).To(Equal([]EventType{EventError}))
+		Expect(events[0].Err).To(Equal(err))
+	})
+	It("should be notified while waiting on a healthy leader", func() {
+		leaderPod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "testns"},
+		}
+		client := fake.NewClientBuilder().WithObjects(myPod, leaderPod, lockHeldBy("other")).Build()
+		ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+		defer cancel()
+		err := Become(ctx, "leader-test", WithClient(client), WithObserver(observer), WithObserver(observer))
+		Expect(err).ShouldNot(BeNil())
+		Expect(eventTypes()).To(Equal([]EventType{
+			EventLockFound, EventLockFound,
+			EventWaiting, EventWaiting,
+			EventError, EventError,
+		}))
+		Expect(events[2].Leader).To(Equal("other"))
+		Expect(events[2].Health).To(Equal(LeaderHealthy))
+	})
+	It("should be notified when evicting the leader", func() {
+		leaderPod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "testns"},
+			Status:     corev1.PodStatus{Phase: corev1.PodFailed, Reason: "Evicted"},
+		}
+		client := fake.NewClientBuilder().WithObjects(myPod, leaderPod, lockHeldBy("other")).Build()
+		ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+		defer cancel()
+		_ = Become(ctx, "leader-test", WithClient(client), WithObserver(observer))
+		Expect(eventTypes()).To(ContainElement(EventEvictingLeader))
+	})
+	It("should be notified when the leader is dead", func() {
+		client := fake.NewClientBuilder().WithObjects(myPod, lockHeldBy("other")).Build()
+		ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+		defer cancel()
+		_ = Become(ctx, "leader-test", WithClient(client), WithObserver(observer))
+		Expect(eventTypes()).To(ContainElement(EventLeaderDead))
+	})
+})
@@ -0,0 +1,453 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/retry"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// HolderAnnotation records the identity of the holder of a lock taken
+	// off-cluster. See WithOffCluster.
+	HolderAnnotation = "operator-lib/leader-holder"
+
+	// HeartbeatAnnotation records the last time the holder of a lock taken
+	// off-cluster renewed it. See WithOffCluster.
+	HeartbeatAnnotation = "operator-lib/leader-heartbeat"
+)
+
+// heartbeatIntervalKey is the key of the lock data recording the heartbeat
+// interval of a leader that took the lock off-cluster.
+const heartbeatIntervalKey = "heartbeat-interval"
+
+// defaultHeartbeatInterval is how often a leader that took a lock off-cluster
+// renews it by default.
+const defaultHeartbeatInterval = 10 * time.Second
+
+// heartbeatExpiryIntervals is the number of heartbeat intervals after which a
+// lock taken off-cluster is considered abandoned by its holder.
+const heartbeatExpiryIntervals = 3
+
+// releaseTimeout bounds the time spent deleting a lock taken off-cluster once
+// the election's context is done.
+const releaseTimeout = 5 * time.Second
+
+// WithOffCluster returns an Option that makes Become run outside of a pod,
+// e.g. while running an operator locally during development.
+//
+// The current process is identified by its Identity, which defaults to the
+// hostname and process ID. If a cluster is configured, either with WithClient
+// or through a kubeconfig, the lock is still a ConfigMap in the cluster, so
+// that local instances and in-cluster deployments of the operator can be run
+// side by side without both reconciling. As there is no pod to own the lock,
+// the lock records its holder in HolderAnnotation and is renewed through
+// HeartbeatAnnotation until the election's context is done, at which point it
+// is deleted. A lock whose holder stopped renewing it for several heartbeat
+// intervals is considered abandoned and is deleted by the next candidate,
+// whether it runs off-cluster or in a pod.
+//
+// If no cluster is configured, the lock is a file named after the lock in the
+// directory set with WithLockDir, held until the election's context is done.
+//
+// The namespace of a lock in the cluster defaults to the namespace of the
+// current kubeconfig context. Checker, the leader label and the leader
+// condition only apply to pods, and are ignored off-cluster.
+func WithOffCluster() Option {
+	return func(c *Config) error {
+		c.OffCluster = true
+		return nil
+	}
+}
+
+// WithLockDir returns an Option that sets the directory of the file locks
+// taken off-cluster when no cluster is configured. It defaults to the
+// temporary directory of the operating system.
+func WithLockDir(dir string) Option {
+	return func(c *Config) error {
+		c.LockDir = dir
+		return nil
+	}
+}
+
+// WithHeartbeatInterval returns an Option that sets how often a lock taken
+// off-cluster is renewed.
+func WithHeartbeatInterval(interval time.Duration) Option {
+	return func(c *Config) error {
+		if interval <= 0 {
+			return fmt.Errorf("heartbeat interval must be positive, got %s", interval)
+		}
+		c.HeartbeatInterval = interval
+		return nil
+	}
+}
+
+// offClusterIdentity returns an identity for the current process derived from
+// the hostname and process ID.
+func offClusterIdentity() (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", fmt.Errorf("unable to determine an off-cluster identity: %w", err)
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid()), nil
+}
+
+func (c *Config) heartbeatInterval() time.Duration {
+	if c.HeartbeatInterval == 0 {
+		return defaultHeartbeatInterval
+	}
+	return c.HeartbeatInterval
+}
+
+// offClusterNamespace returns the configured namespace, the namespace the
+// operator is running in, or the namespace of the current kubeconfig context.
+func (c *Config) offClusterNamespace() (string, error) {
+	ns, err := c.namespace()
+	if !errors.Is(err, ErrNoNamespace) {
+		return ns, err
+	}
+	loader := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(), &clientcmd.ConfigOverrides{})
+	ns, _, err = loader.Namespace()
+	return ns, err
+}
+
+func (c *Config) becomeOffCluster(ctx context.Context, lockName string) (*Leadership, error) {
+	if c.Identity == "" {
+		id, err := offClusterIdentity()
+		if err != nil {
+			return nil, err
+		}
+		c.Identity = id
+	}
+	log.Info("Running off-cluster.", "identity", c.Identity)
+
+	if c.Client == nil {
+		return c.becomeFileLock(ctx, lockName)
+	}
+	ns, err := c.offClusterNamespace()
+	if err != nil {
+		return nil, err
+	}
+	if c.Preflight {
+		if err := c.preflight(ctx, c.permissions(ns)); err != nil {
+			return nil, err
+		}
+	}
+	return c.becomeClusterLock(ctx, ns, lockName)
+}
+
+// becomeClusterLock takes the ConfigMap lock of lockName in ns on behalf of the
+// current process.
+func (c *Config) becomeClusterLock(ctx context.Context, ns, lockName string) (*Leadership, error) {
+	key := crclient.ObjectKey{Namespace: ns, Name: lockName}
+	backoff := time.Second
+	for {
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        lockName,
+				Namespace:   ns,
+				Labels:      lockLabels(),
+				Annotations: map[string]string{HolderAnnotation: c.Identity},
+			},
+			Data: c.offClusterHolderData(),
+		}
+		cm.Annotations[HeartbeatAnnotation] = c.clock().Now().UTC().Format(time.RFC3339)
+
+		err := c.Client.Create(ctx, cm)
+		switch {
+		case err == nil:
+			log.Info("Became the leader.")
+			return c.becameOffClusterLeader(ctx, cm)
+		case apierrors.IsAlreadyExists(err):
+			existing := &corev1.ConfigMap{}
+			err := c.Client.Get(ctx, key, existing)
+			switch {
+			case apierrors.IsNotFound(err):
+				log.Info("Leader lock configmap not found.")
+				continue // configmap got lost ... try to create it again
+			case c.isTransient(err):
+				log.Info("Transient error getting the leader lock, retrying.", "error", err.Error())
+			case err != nil:
+				log.Error(err, "Unknown error trying to get ConfigMap")
+				return nil, err
+			case existing.Annotations[HolderAnnotation] == c.Identity:
+				log.Info("Found existing lock with my identity. I was likely restarted.")
+				err := c.retryTransient(ctx, "renew lock", func() error {
+					if err := c.renewHeartbeat(ctx, key); err != nil {
+						return err
+					}
+					return c.Client.Get(ctx, key, existing)
+				})
+				if err != nil {
+					return nil, err
+				}
+				return c.becameOffClusterLeader(ctx, existing)
+			case existing.Annotations[HolderAnnotation] != "":
+				deleted, err := c.handleOffClusterLock(ctx, existing)
+				if err != nil {
+					return nil, err
+				}
+				if deleted {
+					continue
+				}
+			default:
+				log.Info("Not the leader. Waiting.")
+				evt := Event{Type: EventWaiting, Namespace: ns, LockName: lockName}
+				if owners := existing.GetOwnerReferences(); len(owners) == 1 {
+					evt.Leader = owners[0].Name
+				}
+				c.notify(evt)
+			}
+		case c.isTransient(err):
+			log.Info("Transient error creating ConfigMap, retrying.", "error", err.Error())
+		default:
+			log.Error(err, "Unknown error creating ConfigMap")
+			return nil, err
+		}
+
+		select {
+		case <-c.clock().After(wait.Jitter(backoff, .2)):
+			if backoff < maxBackoffInterval {
+				backoff *= 2
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// becameOffClusterLeader records the fencing token of lock, and renews lock
+// until ctx is done.
+func (c *Config) becameOffClusterLeader(ctx context.Context, lock *corev1.ConfigMap) (*Leadership, error) {
+	var generation int64
+	err := c.retryTransient(ctx, "record generation", func() (err error) {
+		generation, err = c.ensureGeneration(ctx, lock)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("became the leader but failed to record the fencing token: %w", err)
+	}
+	key := crclient.ObjectKey{Namespace: lock.Namespace, Name: lock.Name}
+	go c.heartbeat(ctx, key)
+
+	c.notify(Event{Type: EventBecameLeader, Namespace: key.Namespace, LockName: key.Name, Leader: c.Identity})
+	c.recordBecameLeader(lock, nil)
+	return &Leadership{
+		Namespace:  key.Namespace,
+		LockName:   key.Name,
+		Identity:   c.Identity,
+		Generation: generation,
+		client:     c.Client,
+		holder:     c.Identity,
+	}, nil
+}
+
+// offClusterHolderData returns the lock data describing the current process
+// as the lock holder.
+func (c *Config) offClusterHolderData() map[string]string {
+	data := map[string]string{
+		holderIdentityKey:    c.Identity,
+		acquiredAtKey:        c.clock().Now().UTC().Format(time.RFC3339),
+		heartbeatIntervalKey: c.heartbeatInterval().String(),
+	}
+	if c.OperatorVersion != "" {
+		data[operatorVersionKey] = c.OperatorVersion
+	}
+	return data
+}
+
+// heartbeat renews the lock identified by key until ctx is done or the lock
+// is lost, then deletes the lock if it is still held.
+func (c *Config) heartbeat(ctx context.Context, key crclient.ObjectKey) {
+	for {
+		select {
+		case <-c.clock().After(c.heartbeatInterval()):
+		case <-ctx.Done():
+			c.releaseClusterLock(key)
+			return
+		}
+		err := c.renewHeartbeat(ctx, key)
+		switch {
+		case errors.Is(err, ErrLockLost):
+			log.Error(err, "Lost the leader lock.")
+			c.notify(Event{Type: EventError, Namespace: key.Namespace, LockName: key.Name, Leader: c.Identity, Err: err})
+			return
+		case err != nil && ctx.Err() == nil:
+			log.Error(err, "Failed to renew the leader lock.")
+		}
+	}
+}
+
+// renewHeartbeat updates the heartbeat of the lock identified by key. It
+// returns ErrLockLost if the lock is gone or held by another identity.
+func (c *Config) renewHeartbeat(ctx context.Context, key crclient.ObjectKey) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		lock := &corev1.ConfigMap{}
+		err := c.Client.Get(ctx, key, lock)
+		switch {
+		case apierrors.IsNotFound(err):
+			return fmt.Errorf("%w: ConfigMap %s not found", ErrLockLost, key)
+		case err != nil:
+			return err
+		}
+		if lock.Annotations[HolderAnnotation] != c.Identity {
+			return fmt.Errorf("%w: ConfigMap %s is held by %q", ErrLockLost, key, lock.Annotations[HolderAnnotation])
+		}
+		lock.Annotations[HeartbeatAnnotation] = c.clock().Now().UTC().Format(time.RFC3339)
+		return c.Client.Update(ctx, lock)
+	})
+}
+
+// releaseClusterLock deletes the lock identified by key if it is still held
+// by the current process.
+func (c *Config) releaseClusterLock(key crclient.ObjectKey) {
+	ctx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+	defer cancel()
+
+	lock := &corev1.ConfigMap{}
+	if err := c.Client.Get(ctx, key, lock); err != nil {
+		return
+	}
+	if lock.Annotations[HolderAnnotation] != c.Identity {
+		return
+	}
+	err := c.Client.Delete(ctx, lock, crclient.Preconditions{UID: &lock.UID, ResourceVersion: &lock.ResourceVersion})
+	if err != nil && !apierrors.IsNotFound(err) {
+		log.Error(err, "Failed to release the leader lock.")
+		return
+	}
+	log.Info("Released the leader lock.")
+}
+
+// heartbeatExpired returns true if the holder of lock has not renewed it for
+// heartbeatExpiryIntervals of the heartbeat interval it recorded. Locks with
+// a missing or invalid heartbeat are considered expired.
+func heartbeatExpired(lock *corev1.ConfigMap, now time.Time) bool {
+	renewedAt, err := time.Parse(time.RFC3339, lock.Annotations[HeartbeatAnnotation])
+	if err != nil {
+		return true
+	}
+	interval, err := time.ParseDuration(lock.Data[heartbeatIntervalKey])
+	if err != nil || interval <= 0 {
+		interval = defaultHeartbeatInterval
+	}
+	return now.Sub(renewedAt) > heartbeatExpiryIntervals*interval
+}
+
+// handleOffClusterLock inspects a lock taken off-cluster by another process,
+// and deletes it if its holder stopped renewing it, whether the current
+// candidate runs in a pod or off-cluster. It returns true if the lock was
+// deleted or changed meanwhile, in which case it can be taken right away.
+func (c *Config) handleOffClusterLock(ctx context.Context, lock *corev1.ConfigMap) (bool, error) {
+	holder := lock.Annotations[HolderAnnotation]
+	evt := Event{Namespace: lock.Namespace, LockName: lock.Name, Leader: holder}
+	switch {
+	case !heartbeatExpired(lock, c.clock().Now()):
+		log.Info("Not the leader. Waiting.", "leader", holder)
+		evt.Type = EventWaiting
+		c.notify(evt)
+		return false, nil
+	case c.disabled[featureExpiredLockTakeover]:
+		log.Info("Leader stopped renewing the lock, but this candidate is not allowed to delete it. Waiting.", "leader", holder)
+		evt.Type = EventWaiting
+		c.notify(evt)
+		return false, nil
+	}
+
+	log.Info("Leader stopped renewing the lock. Deleting it.", "leader", holder)
+	evt.Type, evt.Health = EventLeaderDead, LeaderExpired
+	c.notify(evt)
+	// The preconditions make the deletion fail if the holder renewed the lock
+	// or another candidate took it in the meantime.
+	err := c.Client.Delete(ctx, lock, crclient.Preconditions{
+		UID:             &lock.UID,
+		ResourceVersion: &lock.ResourceVersion,
+	})
+	switch {
+	case err == nil || apierrors.IsNotFound(err) || apierrors.IsConflict(err):
+		return true, nil
+	case c.isTransient(err):
+		log.Info("Transient error deleting the expired lock, retrying.", "error", err.Error())
+		return false, nil
+	}
+	return false, err
+}
+
+// becomeFileLock takes the file lock of lockName in LockDir on behalf of the
+// current process.
+func (c *Config) becomeFileLock(ctx context.Context, lockName string) (*Leadership, error) {
+	dir := c.LockDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	path := filepath.Join(dir, lockName+".lock")
+	log.Info("No cluster is configured. Using a local file lock.", "path", path)
+
+	backoff := time.Second
+	for {
+		f, err := lockFile(path)
+		switch {
+		case err == nil:
+			if err := f.Truncate(0); err == nil {
+				_, _ = f.WriteAt([]byte(c.Identity), 0)
+			}
+			go func() {
+				<-ctx.Done()
+				f.Close()
+			}()
+			log.Info("Became the leader.")
+			c.notify(Event{Type: EventBecameLeader, LockName: lockName, Leader: c.Identity})
+			return &Leadership{
+				LockName: lockName,
+				Identity: c.Identity,
+				holder:   c.Identity,
+				released: ctx.Done(),
+			}, nil
+		case errors.Is(err, errLocked):
+			holder, _ := ioutil.ReadFile(path)
+			log.Info("Not the leader. Waiting.", "leader", string(holder))
+			c.notify(Event{Type: EventWaiting, LockName: lockName, Leader: strings.TrimSpace(string(holder))})
+		default:
+			return nil, err
+		}
+
+		select {
+		case <-c.clock().After(wait.Jitter(backoff, .2)):
+			if backoff < maxBackoffInterval {
+				backoff *= 2
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// errLocked indicates that a file lock is held by another process.
+var errLocked = errors.New("file is locked by another process")
@@ -0,0 +1,285 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/clock"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Off-cluster leader election", func() {
+	lockKey := crclient.ObjectKey{Namespace: "testns", Name: "leader-test"}
+
+	Describe("offClusterIdentity", func() {
+		It("should end with the process ID", func() {
+			id, err := offClusterIdentity()
+			Expect(err).Should(BeNil())
+			Expect(strings.HasSuffix(id, fmt.Sprintf("-%d", os.Getpid()))).To(BeTrue())
+		})
+	})
+
+	Describe("cluster lock", func() {
+		var (
+			client crclient.Client
+			clk    *clock.FakeClock
+		)
+		acquireAs := func(ctx context.Context, identity string) (*Leadership, error) {
+			return Acquire(ctx, "leader-test", WithClient(client), WithNamespace("testns"),
+				WithOffCluster(), WithIdentity(identity), WithClock(clk))
+		}
+		getLock := func() *corev1.ConfigMap {
+			lock := &corev1.ConfigMap{}
+			Expect(client.Get(context.TODO(), lockKey, lock)).To(Succeed())
+			return lock
+		}
+		BeforeEach(func() {
+			client = fake.NewClientBuilder().Build()
+			clk = clock.NewFakeClock(time.Now())
+		})
+
+		It("should take a lock without owner references", func() {
+			ctx, cancel := context.WithCancel(context.TODO())
+			defer cancel()
+			l, err := acquireAs(ctx, "laptop-1")
+			Expect(err).Should(BeNil())
+			Expect(l.Identity).To(Equal("laptop-1"))
+			Expect(l.Verify(ctx)).To(Succeed())
+
+			lock := getLock()
+			Expect(lock.OwnerReferences).To(BeEmpty())
+			Expect(lock.Annotations).To(HaveKeyWithValue(HolderAnnotation, "laptop-1"))
+			Expect(lock.Annotations).To(HaveKey(HeartbeatAnnotation))
+
+			info, err := GetLeader(ctx, client, "testns", "leader-test")
+			Expect(err).Should(BeNil())
+			Expect(info.Identity).To(Equal("laptop-1"))
+			Expect(info.Health).To(Equal(LeaderHealthy))
+		})
+		It("should wait while the holder renews the lock", func() {
+			ctx, cancel := context.WithCancel(context.TODO())
+			defer cancel()
+			_, err := acquireAs(ctx, "laptop-1")
+			Expect(err).Should(BeNil())
+
+			waitCtx, waitCancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+			defer waitCancel()
+			_, err = acquireAs(waitCtx, "laptop-2")
+			Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+		})
+		It("should renew the heartbeat", func() {
+			ctx, cancel := context.WithCancel(context.TODO())
+			defer cancel()
+			_, err := acquireAs(ctx, "laptop-1")
+			Expect(err).Should(BeNil())
+			before := getLock().Annotations[HeartbeatAnnotation]
+
+			Eventually(clk.HasWaiters).Should(BeTrue())
+			clk.Step(time.Minute)
+			Eventually(func() string {
+				return getLock().Annotations[HeartbeatAnnotation]
+			}).ShouldNot(Equal(before))
+		})
+		It("should release the lock once the context is done", func() {
+			ctx, cancel := context.WithCancel(context.TODO())
+			_, err := acquireAs(ctx, "laptop-1")
+			Expect(err).Should(BeNil())
+			cancel()
+			Eventually(func() bool {
+				return apierrors.IsNotFound(client.Get(context.TODO(), lockKey, &corev1.ConfigMap{}))
+			}).Should(BeTrue())
+		})
+		It("should take over a lock whose heartbeat expired", func() {
+			stale := clk.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+			Expect(client.Create(context.TODO(), &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      lockKey.Name,
+					Namespace: lockKey.Namespace,
+					Annotations: map[string]string{
+						HolderAnnotation:    "laptop-1",
+						HeartbeatAnnotation: stale,
+					},
+				},
+			})).To(Succeed())
+
+			info, err := GetLeader(context.TODO(), client, "testns", "leader-test")
+			Expect(err).Should(BeNil())
+			Expect(info.Health).To(Equal(LeaderExpired))
+
+			ctx, cancel := context.WithCancel(context.TODO())
+			defer cancel()
+			_, err = acquireAs(ctx, "laptop-2")
+			Expect(err).Should(BeNil())
+			Expect(getLock().Annotations).To(HaveKeyWithValue(HolderAnnotation, "laptop-2"))
+		})
+		It("should back off on transient errors getting the lock", func() {
+			_, err := acquireAs(context.TODO(), "laptop-1")
+			Expect(err).Should(BeNil())
+			failing := &failingClient{Client: client, configMaps: true,
+				err: apierrors.NewServiceUnavailable("down"), failures: 1}
+			client = failing
+
+			ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+			defer cancel()
+			_, err = acquireAs(ctx, "laptop-2")
+			Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+			Expect(failing.failures).To(BeZero())
+		})
+		It("should return permanent errors getting the lock", func() {
+			_, err := acquireAs(context.TODO(), "laptop-1")
+			Expect(err).Should(BeNil())
+			client = &failingClient{Client: client, configMaps: true,
+				err: apierrors.NewForbidden(schema.GroupResource{Resource: "configmaps"}, lockKey.Name,
+					errors.New("denied")), failures: 100}
+
+			_, err = acquireAs(context.TODO(), "laptop-2")
+			Expect(apierrors.IsForbidden(err)).To(BeTrue())
+		})
+		It("should continue as the leader after a restart", func() {
+			ctx, cancel := context.WithCancel(context.TODO())
+			defer cancel()
+			first, err := acquireAs(ctx, "laptop-1")
+			Expect(err).Should(BeNil())
+			again, err := acquireAs(ctx, "laptop-1")
+			Expect(err).Should(BeNil())
+			Expect(again.Generation).To(Equal(first.Generation))
+		})
+	})
+
+	Describe("in-cluster candidate", func() {
+		var (
+			client crclient.Client
+			clk    *clock.FakeClock
+		)
+		acquireInCluster := func(ctx context.Context) (*Leadership, error) {
+			return Acquire(ctx, "leader-test", WithClient(client), WithNamespace("testns"),
+				WithPodName("pod-1"), WithClock(clk))
+		}
+		createOffClusterLock := func(heartbeat time.Time) {
+			Expect(client.Create(context.TODO(), &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      lockKey.Name,
+					Namespace: lockKey.Namespace,
+					Annotations: map[string]string{
+						HolderAnnotation:    "laptop-1",
+						HeartbeatAnnotation: heartbeat.UTC().Format(time.RFC3339),
+					},
+				},
+			})).To(Succeed())
+		}
+		BeforeEach(func() {
+			client = fake.NewClientBuilder().WithObjects(&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "pod-1", Namespace: "testns", UID: "uid-1"},
+			}).Build()
+			clk = clock.NewFakeClock(time.Now())
+		})
+
+		It("should wait while an off-cluster holder renews the lock", func() {
+			createOffClusterLock(clk.Now())
+
+			ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+			defer cancel()
+			_, err := acquireInCluster(ctx)
+			Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+
+			lock := &corev1.ConfigMap{}
+			Expect(client.Get(context.TODO(), lockKey, lock)).To(Succeed())
+			Expect(lock.Annotations).To(HaveKeyWithValue(HolderAnnotation, "laptop-1"))
+		})
+		It("should take over a lock abandoned by an off-cluster holder", func() {
+			createOffClusterLock(clk.Now().Add(-time.Hour))
+
+			ctx, cancel := context.WithCancel(context.TODO())
+			defer cancel()
+			done := make(chan error, 1)
+			go func() {
+				_, err := acquireInCluster(ctx)
+				done <- err
+			}()
+			Eventually(clk.HasWaiters).Should(BeTrue())
+			clk.Step(time.Minute)
+			Eventually(done).Should(Receive(BeNil()))
+
+			lock := &corev1.ConfigMap{}
+			Expect(client.Get(context.TODO(), lockKey, lock)).To(Succeed())
+			Expect(lock.Annotations).NotTo(HaveKey(HolderAnnotation))
+			Expect(lock.OwnerReferences).To(HaveLen(1))
+			Expect(lock.OwnerReferences[0].Name).To(Equal("pod-1"))
+		})
+		It("should be waited for by an off-cluster candidate", func() {
+			ctx, cancel := context.WithCancel(context.TODO())
+			defer cancel()
+			_, err := acquireInCluster(ctx)
+			Expect(err).Should(BeNil())
+
+			waitCtx, waitCancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+			defer waitCancel()
+			_, err = Acquire(waitCtx, "leader-test", WithClient(client), WithNamespace("testns"),
+				WithOffCluster(), WithIdentity("laptop-1"), WithClock(clk))
+			Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+		})
+	})
+
+	Describe("file lock", func() {
+		var dir string
+		acquireAs := func(ctx context.Context, identity string) (*Leadership, error) {
+			config := &Config{OffCluster: true, LockDir: dir, Identity: identity}
+			return config.becomeOffCluster(ctx, "leader-test")
+		}
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "leader-test")
+			Expect(err).Should(BeNil())
+		})
+		AfterEach(func() {
+			os.RemoveAll(dir)
+		})
+
+		It("should allow a single holder at a time", func() {
+			ctx, cancel := context.WithCancel(context.TODO())
+			l, err := acquireAs(ctx, "laptop-1")
+			Expect(err).Should(BeNil())
+			Expect(l.Verify(ctx)).To(Succeed())
+
+			waitCtx, waitCancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+			defer waitCancel()
+			_, err = acquireAs(waitCtx, "laptop-2")
+			Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+
+			cancel()
+			Expect(errors.Is(l.Verify(context.TODO()), ErrStaleLeader)).To(BeTrue())
+			Eventually(func() error {
+				otherCtx, otherCancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+				defer otherCancel()
+				_, err := acquireAs(otherCtx, "laptop-2")
+				return err
+			}).Should(Succeed())
+		})
+	})
+})
@@ -0,0 +1,160 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leader
+
+import (
+	"context"
+
+	"github.com/operator-framework/operator-lib/preflight"
+)
+
+// Features of Become, as named in the permissions it needs.
+const (
+	featureElection            = "leader election"
+	featureNodeTakeover        = "NotReady node takeover"
+	featureEviction            = "evicted leader replacement"
+	featureVersionHandoff      = "version handoff"
+	featureExpiredLockTakeover = "expired lock takeover"
+	featureLeaderLabel         = "leader label"
+	featureLeaderCondition     = "leader condition"
+	featureEvents              = "event recording"
+	featureSharding            = "sharding"
+)
+
+// WithPreflight returns an Option that makes Become and BecomeShards check
+// their permissions with the preflight package before running. They fail
+// immediately if a permission they require is missing, and skip the features
+// whose optional permissions are missing: without permission to get nodes,
+// delete pods or delete ConfigMaps, they do not replace leaders on NotReady
+// nodes, and without permission to delete pods, they do not replace evicted
+// leaders or leaders running an older operator version. They wait for such
+// leaders to be garbage collected instead. Without permission to create and
+// patch events, they do not record events with the recorder set by
+// WithEventRecorder.
+func WithPreflight() Option {
+	return func(c *Config) error {
+		c.Preflight = true
+		return nil
+	}
+}
+
+// Permissions returns the permissions Become needs in namespace when given
+// opts. Permissions of features Become can do without are optional.
+func Permissions(namespace string, opts ...Option) ([]preflight.Permission, error) {
+	c := &Config{}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	return c.permissions(namespace), nil
+}
+
+// ShardPermissions returns the permissions BecomeShards needs in namespace
+// when given opts. Permissions of features BecomeShards can do without are
+// optional.
+func ShardPermissions(namespace string, opts ...Option) ([]preflight.Permission, error) {
+	c := &Config{}
+	for _, opt := range opts {
+		if err := opt(c); err != nil {
+			return nil, err
+		}
+	}
+	return c.shardPermissions(namespace), nil
+}
+
+func (c *Config) permissions(ns string) []preflight.Permission {
+	perm := func(verb, resource, feature string, optional bool) preflight.Permission {
+		return preflight.Permission{Verb: verb, Resource: resource, Namespace: ns, Feature: feature, Optional: optional}
+	}
+	perms := []preflight.Permission{
+		perm("get", "configmaps", featureElection, false),
+		perm("create", "configmaps", featureElection, false),
+		perm("update", "configmaps", featureElection, false),
+	}
+	if c.Recorder != nil {
+		perms = append(perms,
+			perm("create", "events", featureEvents, true),
+			perm("patch", "events", featureEvents, true),
+		)
+	}
+	if c.OffCluster {
+		return append(perms, perm("delete", "configmaps", featureExpiredLockTakeover, true))
+	}
+
+	perms = append(perms,
+		perm("get", "pods", featureElection, false),
+		preflight.Permission{Verb: "get", Resource: "nodes", Feature: featureNodeTakeover, Optional: true},
+		perm("delete", "pods", featureNodeTakeover, true),
+		perm("delete", "configmaps", featureNodeTakeover, true),
+		perm("delete", "pods", featureEviction, true),
+		perm("delete", "configmaps", featureExpiredLockTakeover, true),
+	)
+	if c.VersionHandoff {
+		perms = append(perms, perm("delete", "pods", featureVersionHandoff, true))
+	}
+	if c.LeaderLabelKey != "" {
+		perms = append(perms,
+			perm("list", "pods", featureLeaderLabel, false),
+			perm("patch", "pods", featureLeaderLabel, false),
+		)
+	}
+	if c.LeaderCondition != "" {
+		perms = append(perms,
+			perm("list", "pods", featureLeaderCondition, false),
+			preflight.Permission{Verb: "patch", Resource: "pods", Subresource: "status", Namespace: ns,
+				Feature: featureLeaderCondition},
+		)
+	}
+	return perms
+}
+
+// shardPermissions returns the permissions BecomeShards needs in ns: those of
+// Become, but for the leader label and condition it ignores, plus those needed
+// to count peers and release shards.
+func (c *Config) shardPermissions(ns string) []preflight.Permission {
+	var perms []preflight.Permission
+	for _, p := range c.permissions(ns) {
+		if p.Feature != featureLeaderLabel && p.Feature != featureLeaderCondition {
+			perms = append(perms, p)
+		}
+	}
+	return append(perms,
+		preflight.Permission{Verb: "list", Resource: "pods", Namespace: ns, Feature: featureSharding},
+		preflight.Permission{Verb: "delete", Resource: "configmaps", Namespace: ns, Feature: featureSharding},
+	)
+}
+
+// preflight checks perms. It returns an error if a required permission is
+// missing, and disables the features whose optional permissions are missing.
+func (c *Config) preflight(ctx context.Context, perms []preflight.Permission) error {
+	report, err := preflight.Check(ctx, c.Client, perms...)
+	if err != nil {
+		return err
+	}
+	if err := report.Err(); err != nil {
+		return err
+	}
+	c.disabled = map[string]bool{}
+	for _, p := range perms {
+		if p.Optional && !report.Allowed(p) {
+			if !c.disabled[p.Feature] {
+				log.Info("Disabling feature due to missing permission.", "feature", p.Feature, "permission", p.String())
+			}
+			c.disabled[p.Feature] = true
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leader
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/operator-framework/operator-lib/preflight"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// reviewClient answers SelfSubjectAccessReviews, allowing every action but the
+// denied ones.
+type reviewClient struct {
+	crclient.Client
+	denied map[string]bool
+}
+
+func (c *reviewClient) Create(ctx context.Context, obj crclient.Object, opts ...crclient.CreateOption) error {
+	review, ok := obj.(*authorizationv1.SelfSubjectAccessReview)
+	if !ok {
+		return c.Client.Create(ctx, obj, opts...)
+	}
+	attrs := review.Spec.ResourceAttributes
+	review.Status.Allowed = !c.denied[attrs.Verb+" "+attrs.Resource]
+	return nil
+}
+
+var _ = Describe("Preflight", func() {
+	Describe("Permissions", func() {
+		It("should include the permissions of the configured features", func() {
+			perms, err := Permissions("testns")
+			Expect(err).Should(BeNil())
+			Expect(perms).To(ContainElement(preflight.Permission{
+				Verb: "get", Resource: "configmaps", Namespace: "testns", Feature: featureElection,
+			}))
+			for _, p := range perms {
+				Expect(p.Feature).NotTo(Equal(featureLeaderLabel))
+			}
+
+			perms, err = Permissions("testns", WithLeaderLabel(LeaderLabel, "true"))
+			Expect(err).Should(BeNil())
+			Expect(perms).To(ContainElement(preflight.Permission{
+				Verb: "patch", Resource: "pods", Namespace: "testns", Feature: featureLeaderLabel,
+			}))
+		})
+		It("should include the permissions of sharding", func() {
+			perms, err := ShardPermissions("testns", WithLeaderLabel(LeaderLabel, "true"))
+			Expect(err).Should(BeNil())
+			Expect(perms).To(ContainElements(
+				preflight.Permission{Verb: "list", Resource: "pods", Namespace: "testns", Feature: featureSharding},
+				preflight.Permission{Verb: "delete", Resource: "configmaps", Namespace: "testns", Feature: featureSharding},
+			))
+			for _, p := range perms {
+				Expect(p.Feature).NotTo(Equal(featureLeaderLabel))
+			}
+		})
+		It("should include optional event permissions with a recorder", func() {
+			for _, opts := range [][]Option{nil, {WithOffCluster()}} {
+				perms, err := Permissions("testns", append(opts, WithEventRecorder(record.NewFakeRecorder(1)))...)
+				Expect(err).Should(BeNil())
+				Expect(perms).To(ContainElements(
+					preflight.Permission{Verb: "create", Resource: "events", Namespace: "testns",
+						Feature: featureEvents, Optional: true},
+					preflight.Permission{Verb: "patch", Resource: "events", Namespace: "testns",
+						Feature: featureEvents, Optional: true},
+				))
+			}
+		})
+	})
+
+	Describe("WithPreflight", func() {
+		var client *reviewClient
+		BeforeEach(func() {
+			readNamespace = func() (string, error) {
+				return "testns", nil
+			}
+			os.Setenv("POD_NAME", "pod-b")
+			client = &reviewClient{
+				Client: fake.NewClientBuilder().WithObjects(
+					&corev1.Node{
+						ObjectMeta: metav1.ObjectMeta{Name: "node-a"},
+						Status: corev1.NodeStatus{Conditions: []corev1.NodeCondition{
+							{Type: corev1.NodeReady, Status: corev1.ConditionFalse},
+						}},
+					},
+					&corev1.Pod{
+						ObjectMeta: metav1.ObjectMeta{Name: "pod-a", Namespace: "testns", UID: "a"},
+						Spec:       corev1.PodSpec{NodeName: "node-a"},
+					},
+					&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-b", Namespace: "testns", UID: "b"}},
+					&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+						Name:      "leader-test",
+						Namespace: "testns",
+						OwnerReferences: []metav1.OwnerReference{
+							{APIVersion: "v1", Kind: "Pod", Name: "pod-a", UID: "a"},
+						},
+					}},
+				).Build(),
+				denied: map[string]bool{},
+			}
+		})
+
+		It("should fail when a required permission is missing", func() {
+			client.denied["create configmaps"] = true
+			err := Become(context.TODO(), "leader-test", WithClient(client), WithPreflight())
+			Expect(errors.Is(err, preflight.ErrMissingPermissions)).To(BeTrue())
+		})
+		It("should wait instead of replacing a leader on a NotReady node without permission", func() {
+			client.denied["get nodes"] = true
+			ctx, cancel := context.WithCancel(context.TODO())
+			defer cancel()
+			var events []EventType
+			observer := ObserverFunc(func(e Event) {
+				events = append(events, e.Type)
+				if e.Type == EventWaiting {
+					cancel()
+				}
+			})
+			err := Become(ctx, "leader-test", WithClient(client), WithPreflight(), WithObserver(observer))
+			Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+			Expect(events).NotTo(ContainElement(EventLeaderDead))
+			Expect(client.Get(context.TODO(), crclient.ObjectKey{Namespace: "testns", Name: "pod-a"}, &corev1.Pod{})).To(Succeed())
+		})
+		It("should check the permissions of BecomeShards", func() {
+			client.denied["list pods"] = true
+			err := BecomeShards(context.TODO(), "leader-test", 2, nil, WithClient(client), WithPreflight())
+			Expect(errors.Is(err, preflight.ErrMissingPermissions)).To(BeTrue())
+		})
+		It("should not record events without permission", func() {
+			client.denied["create events"] = true
+			recorder := record.NewFakeRecorder(10)
+			err := Become(context.TODO(), "leader-test", WithClient(client), WithPreflight(),
+				WithEventRecorder(recorder))
+			Expect(err).Should(BeNil())
+			Expect(recorder.Events).To(BeEmpty())
+		})
+		It("should replace a leader on a NotReady node with permission", func() {
+			err := Become(context.TODO(), "leader-test", WithClient(client), WithPreflight())
+			Expect(err).Should(BeNil())
+		})
+	})
+})
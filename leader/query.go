@@ -0,0 +1,168 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// Keys of the lock data describing the leader that created the lock.
+const (
+	holderPodKey       = "holder-pod"
+	holderPodUIDKey    = "holder-pod-uid"
+	holderNodeKey      = "holder-node"
+	acquiredAtKey      = "acquired-at"
+	operatorVersionKey = "operator-version"
+	holderIdentityKey  = "holder-identity"
+	generationKey      = "generation"
+)
+
+var (
+	// ErrNoLeader indicates that no lock exists, so there is no leader.
+	ErrNoLeader = errors.New("no leader lock found")
+
+	// ErrInvalidLock indicates that a lock exists, but is neither owned by
+	// exactly one pod nor held off-cluster.
+	ErrInvalidLock = errors.New("invalid leader lock")
+)
+
+// LeaderHealth describes the health of a leader pod.
+type LeaderHealth string
+
+const (
+	// LeaderHealthy indicates that the leader pod is running normally.
+	LeaderHealthy LeaderHealth = "Healthy"
+	// LeaderDeleted indicates that the leader pod no longer exists, and the
+	// lock is waiting to be garbage collected.
+	LeaderDeleted LeaderHealth = "Deleted"
+	// LeaderEvicted indicates that the leader pod has been evicted. Become
+	// deletes evicted leaders.
+	LeaderEvicted LeaderHealth = "Evicted"
+	// LeaderNodeNotReady indicates that the leader pod runs on a node that is
+	// not ready. Become deletes such leaders along with their lock.
+	LeaderNodeNotReady LeaderHealth = "NodeNotReady"
+	// LeaderExpired indicates that a leader that took the lock off-cluster
+	// stopped renewing it. Become deletes such locks.
+	LeaderExpired LeaderHealth = "Expired"
+)
+
+// LeaderInfo describes the holder of a leader lock.
+type LeaderInfo struct {
+	// PodName and PodUID identify the leader pod.
+	PodName string
+	PodUID  types.UID
+	// NodeName is the node the leader pod was scheduled on.
+	NodeName string
+	// AcquiredAt is the time the leader created the lock. It is zero if the
+	// lock was created by a version of this library that did not record it.
+	AcquiredAt time.Time
+	// OperatorVersion is the version recorded by the leader, if any.
+	OperatorVersion string
+	// Identity is the stable identity of the leader, if any.
+	Identity string
+	// Generation is the fencing token of the leader. It is zero if the
+	// leader has not recorded one yet.
+	Generation int64
+	// RenewedAt is the last time a leader that took the lock off-cluster
+	// renewed it. It is zero for leader pods.
+	RenewedAt time.Time
+	// Health is the health of the leader pod, as judged by Become.
+	Health LeaderHealth
+}
+
+// GetLeader returns information about the current holder of the lock named
+// lockName in namespace. It returns ErrNoLeader if the lock does not exist,
+// and ErrInvalidLock if it is neither owned by exactly one pod nor held
+// off-cluster.
+func GetLeader(ctx context.Context, client crclient.Client, namespace, lockName string) (*LeaderInfo, error) {
+	lock := &corev1.ConfigMap{}
+	key := crclient.ObjectKey{Namespace: namespace, Name: lockName}
+	if err := client.Get(ctx, key, lock); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, ErrNoLeader
+		}
+		return nil, err
+	}
+	return getLeaderInfo(ctx, client, lock)
+}
+
+// getLeaderInfo returns information about the holder of lock.
+func getLeaderInfo(ctx context.Context, client crclient.Client, lock *corev1.ConfigMap) (*LeaderInfo, error) {
+	if holder, ok := lock.Annotations[HolderAnnotation]; ok && len(lock.GetOwnerReferences()) == 0 {
+		return getOffClusterLeaderInfo(lock, holder), nil
+	}
+	owners := lock.GetOwnerReferences()
+	if len(owners) != 1 || owners[0].Kind != "Pod" {
+		return nil, fmt.Errorf("%w: ConfigMap %s/%s must have exactly one pod owner reference",
+			ErrInvalidLock, lock.Namespace, lock.Name)
+	}
+
+	info := &LeaderInfo{
+		PodName:         owners[0].Name,
+		PodUID:          owners[0].UID,
+		NodeName:        lock.Data[holderNodeKey],
+		OperatorVersion: lock.Data[operatorVersionKey],
+		Identity:        lock.Data[holderIdentityKey],
+	}
+	setLockRecord(info, lock)
+
+	leaderPod, health, err := getLeaderPod(ctx, client, lock.Namespace, owners[0])
+	if err != nil {
+		return nil, err
+	}
+	info.Health = health
+	if leaderPod != nil && info.NodeName == "" {
+		info.NodeName = leaderPod.Spec.NodeName
+	}
+	return info, nil
+}
+
+// getOffClusterLeaderInfo returns information about the holder of a lock taken
+// off-cluster.
+func getOffClusterLeaderInfo(lock *corev1.ConfigMap, holder string) *LeaderInfo {
+	info := &LeaderInfo{
+		OperatorVersion: lock.Data[operatorVersionKey],
+		Identity:        holder,
+		Health:          LeaderHealthy,
+	}
+	setLockRecord(info, lock)
+	info.RenewedAt, _ = time.Parse(time.RFC3339, lock.Annotations[HeartbeatAnnotation])
+	if heartbeatExpired(lock, time.Now()) {
+		info.Health = LeaderExpired
+	}
+	return info
+}
+
+// setLockRecord sets the generation and acquisition time recorded in lock.
+func setLockRecord(info *LeaderInfo, lock *corev1.ConfigMap) {
+	if generation, ok := lock.Data[generationKey]; ok {
+		info.Generation, _ = parseGeneration(generation)
+	}
+	if acquiredAt, ok := lock.Data[acquiredAtKey]; ok {
+		t, err := time.Parse(time.RFC3339, acquiredAt)
+		if err != nil {
+			log.V(1).Info("Ignoring invalid lock acquisition time", "ConfigMap.Name", lock.Name, "acquiredAt", acquiredAt)
+		}
+		info.AcquiredAt = t
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leader
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("GetLeader", func() {
+	var (
+		client crclient.Client
+		pod    *corev1.Pod
+		node   *corev1.Node
+	)
+	BeforeEach(func() {
+		os.Setenv("POD_NAME", "leader-test")
+		readNamespace = func() (string, error) {
+			return "testns", nil
+		}
+		pod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "leader-test",
+				Namespace: "testns",
+				UID:       "leader-uid",
+			},
+			Spec: corev1.PodSpec{NodeName: "mynode"},
+		}
+		node = &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "mynode"},
+			Status: corev1.NodeStatus{
+				Conditions: []corev1.NodeCondition{
+					{Type: corev1.NodeReady, Status: corev1.ConditionTrue},
+				},
+			},
+		}
+	})
+
+	It("should return ErrNoLeader if there is no lock", func() {
+		client = fake.NewClientBuilder().WithObjects(pod, node).Build()
+		_, err := GetLeader(context.TODO(), client, "testns", "leader-test")
+		Expect(err).To(Equal(ErrNoLeader))
+	})
+	It("should return ErrInvalidLock if the lock is not owned by a pod", func() {
+		client = fake.NewClientBuilder().WithObjects(pod, node, &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "leader-test", Namespace: "testns"},
+		}).Build()
+		_, err := GetLeader(context.TODO(), client, "testns", "leader-test")
+		Expect(errors.Is(err, ErrInvalidLock)).To(BeTrue())
+	})
+
+	Describe("after Become", func() {
+		BeforeEach(func() {
+			client = fake.NewClientBuilder().WithObjects(pod, node).Build()
+			err := Become(context.TODO(), "leader-test", WithClient(client), WithOperatorVersion("v1.2.3"))
+			Expect(err).Should(BeNil())
+		})
+		It("should record holder metadata in the lock", func() {
+			lock := &corev1.ConfigMap{}
+			Expect(client.Get(context.TODO(), crclient.ObjectKey{Namespace: "testns", Name: "leader-test"}, lock)).To(Succeed())
+			Expect(lock.Data).To(HaveKeyWithValue(holderPodKey, "leader-test"))
+			Expect(lock.Data).To(HaveKeyWithValue(holderPodUIDKey, "leader-uid"))
+			Expect(lock.Data).To(HaveKeyWithValue(holderNodeKey, "mynode"))
+			Expect(lock.Data).To(HaveKeyWithValue(operatorVersionKey, "v1.2.3"))
+			Expect(lock.Data).To(HaveKey(acquiredAtKey))
+		})
+		It("should return a healthy leader", func() {
+			info, err := GetLeader(context.TODO(), client, "testns", "leader-test")
+			Expect(err).Should(BeNil())
+			Expect(info.PodName).To(Equal("leader-test"))
+			Expect(string(info.PodUID)).To(Equal("leader-uid"))
+			Expect(info.NodeName).To(Equal("mynode"))
+			Expect(info.OperatorVersion).To(Equal("v1.2.3"))
+			Expect(info.AcquiredAt).To(BeTemporally("~", time.Now(), time.Minute))
+			Expect(info.Health).To(Equal(LeaderHealthy))
+		})
+		It("should report a deleted leader", func() {
+			Expect(client.Delete(context.TODO(), pod)).To(Succeed())
+			info, err := GetLeader(context.TODO(), client, "testns", "leader-test")
+			Expect(err).Should(BeNil())
+			Expect(info.Health).To(Equal(LeaderDeleted))
+			Expect(info.NodeName).To(Equal("mynode"))
+		})
+		It("should report an evicted leader", func() {
+			pod.Status.Phase = corev1.PodFailed
+			pod.Status.Reason = "Evicted"
+			Expect(client.Status().Update(context.TODO(), pod)).To(Succeed())
+			info, err := GetLeader(context.TODO(), client, "testns", "leader-test")
+			Expect(err).Should(BeNil())
+			Expect(info.Health).To(Equal(LeaderEvicted))
+		})
+		It("should report a leader on a NotReady node", func() {
+			node.Status.Conditions[0].Status = corev1.ConditionFalse
+			Expect(client.Status().Update(context.TODO(), node)).To(Succeed())
+			info, err := GetLeader(context.TODO(), client, "testns", "leader-test")
+			Expect(err).Should(BeNil())
+			Expect(info.Health).To(Equal(LeaderNodeNotReady))
+		})
+	})
+})
@@ -0,0 +1,99 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leader
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// initialRetryInterval is the first interval between attempts of an API call
+// that failed with a transient error.
+const initialRetryInterval = 500 * time.Millisecond
+
+// IsTransientError returns true if err is likely to go away on its own, such as
+// a timeout, throttling, a server error or a dropped connection. Become
+// retries the API calls failing with such errors instead of returning them.
+// Errors caused by the configuration of the operator or the cluster, such as
+// Forbidden, Unauthorized or Invalid, are permanent, as are context errors.
+func IsTransientError(err error) bool {
+	switch {
+	case err == nil:
+		return false
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return false
+	case apierrors.IsServerTimeout(err), apierrors.IsTimeout(err), apierrors.IsTooManyRequests(err),
+		apierrors.IsInternalError(err), apierrors.IsServiceUnavailable(err), apierrors.IsUnexpectedServerError(err):
+		return true
+	case utilnet.IsConnectionReset(err), utilnet.IsConnectionRefused(err), utilnet.IsProbableEOF(err):
+		return true
+	}
+	var status apierrors.APIStatus
+	if errors.As(err, &status) {
+		return status.Status().Code >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// WithRetryClassifier returns an Option that sets the function deciding which
+// errors of API calls Become retries. It defaults to IsTransientError. A
+// classifier that always returns false makes Become return every error.
+func WithRetryClassifier(isTransient func(error) bool) Option {
+	return func(c *Config) error {
+		c.IsTransient = isTransient
+		return nil
+	}
+}
+
+// isTransient reports whether err should be retried. The classifier is not
+// called with nil errors, which are never transient.
+func (c *Config) isTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if c.IsTransient == nil {
+		return IsTransientError(err)
+	}
+	return c.IsTransient(err)
+}
+
+// retryTransient calls fn until it succeeds or returns an error that is not
+// transient. Transient errors are retried with exponential backoff until ctx
+// is done, in which case the last error is returned.
+func (c *Config) retryTransient(ctx context.Context, operation string, fn func() error) error {
+	backoff := initialRetryInterval
+	for {
+		err := fn()
+		if err == nil || !c.isTransient(err) {
+			return err
+		}
+		log.Info("Transient error, retrying.", "operation", operation, "error", err.Error())
+		select {
+		case <-c.clock().After(wait.Jitter(backoff, .2)):
+			if backoff < maxBackoffInterval {
+				backoff *= 2
+			}
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
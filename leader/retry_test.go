@@ -0,0 +1,152 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leader
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// timeoutError is a net.Error that timed out.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// failingClient fails the first failures Gets of pods, or of ConfigMaps if
+// configMaps is set, and the first updateFailures Updates of ConfigMaps with
+// err.
+type failingClient struct {
+	crclient.Client
+	err            error
+	failures       int
+	configMaps     bool
+	updateFailures int
+}
+
+func (c *failingClient) Get(ctx context.Context, key crclient.ObjectKey, obj crclient.Object) error {
+	_, isPod := obj.(*corev1.Pod)
+	_, isConfigMap := obj.(*corev1.ConfigMap)
+	if (isPod && !c.configMaps || isConfigMap && c.configMaps) && c.failures > 0 {
+		c.failures--
+		return c.err
+	}
+	return c.Client.Get(ctx, key, obj)
+}
+
+func (c *failingClient) Update(ctx context.Context, obj crclient.Object, opts ...crclient.UpdateOption) error {
+	if _, isConfigMap := obj.(*corev1.ConfigMap); isConfigMap && c.updateFailures > 0 {
+		c.updateFailures--
+		return c.err
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+var _ = Describe("Retries", func() {
+	podsResource := schema.GroupResource{Resource: "pods"}
+
+	Describe("IsTransientError", func() {
+		It("should classify errors", func() {
+			Expect(IsTransientError(nil)).To(BeFalse())
+			Expect(IsTransientError(apierrors.NewServiceUnavailable("down"))).To(BeTrue())
+			Expect(IsTransientError(apierrors.NewTooManyRequests("slow down", 1))).To(BeTrue())
+			Expect(IsTransientError(apierrors.NewInternalError(errors.New("boom")))).To(BeTrue())
+			Expect(IsTransientError(apierrors.NewTimeoutError("timeout", 1))).To(BeTrue())
+			Expect(IsTransientError(timeoutError{})).To(BeTrue())
+			Expect(IsTransientError(apierrors.NewForbidden(podsResource, "pod-a", errors.New("denied")))).To(BeFalse())
+			Expect(IsTransientError(apierrors.NewNotFound(podsResource, "pod-a"))).To(BeFalse())
+			Expect(IsTransientError(context.Canceled)).To(BeFalse())
+			Expect(IsTransientError(errors.New("misconfigured"))).To(BeFalse())
+		})
+	})
+
+	Describe("Become", func() {
+		var client *failingClient
+		BeforeEach(func() {
+			readNamespace = func() (string, error) {
+				return "testns", nil
+			}
+			os.Setenv("POD_NAME", "pod-a")
+			client = &failingClient{
+				Client: fake.NewClientBuilder().WithObjects(
+					&corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "pod-a", Namespace: "testns", UID: "a"}},
+				).Build(),
+			}
+		})
+
+		It("should retry transient errors", func() {
+			client.err, client.failures = apierrors.NewServiceUnavailable("down"), 1
+			Expect(Become(context.TODO(), "leader-test", WithClient(client))).To(Succeed())
+			Expect(client.failures).To(BeZero())
+		})
+		It("should retry transient errors recording the generation", func() {
+			client.err, client.updateFailures = apierrors.NewServiceUnavailable("down"), 1
+			l, err := Acquire(context.TODO(), "leader-test", WithClient(client))
+			Expect(err).Should(BeNil())
+			Expect(client.updateFailures).To(BeZero())
+			Expect(l.Verify(context.TODO())).To(Succeed())
+		})
+		It("should fail fast on permanent errors", func() {
+			client.err, client.failures = apierrors.NewForbidden(podsResource, "pod-a", errors.New("denied")), 1
+			err := Become(context.TODO(), "leader-test", WithClient(client))
+			Expect(apierrors.IsForbidden(err)).To(BeTrue())
+		})
+		It("should return the last transient error once the context is done", func() {
+			client.err, client.failures = apierrors.NewServiceUnavailable("down"), 100
+			ctx, cancel := context.WithCancel(context.TODO())
+			cancel()
+			err := Become(ctx, "leader-test", WithClient(client))
+			Expect(apierrors.IsServiceUnavailable(err)).To(BeTrue())
+		})
+		It("should use the configured classifier", func() {
+			client.err, client.failures = apierrors.NewServiceUnavailable("down"), 1
+			never := func(error) bool { return false }
+			err := Become(context.TODO(), "leader-test", WithClient(client), WithRetryClassifier(never))
+			Expect(apierrors.IsServiceUnavailable(err)).To(BeTrue())
+		})
+		It("should not pass nil errors to the configured classifier", func() {
+			Expect(client.Create(context.TODO(), &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "pod-b", Namespace: "testns", UID: "b"},
+			})).To(Succeed())
+			Expect(client.Create(context.TODO(), &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "leader-test",
+					Namespace: "testns",
+					OwnerReferences: []metav1.OwnerReference{
+						{APIVersion: "v1", Kind: "Pod", Name: "pod-b", UID: "b"},
+					},
+				},
+			})).To(Succeed())
+
+			always := func(error) bool { return true }
+			ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+			defer cancel()
+			err := Become(ctx, "leader-test", WithClient(client), WithRetryClassifier(always))
+			Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+		})
+	})
+})
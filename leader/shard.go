@@ -0,0 +1,320 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leader
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// shardResyncInterval defines how often BecomeShards looks for shards to
+// claim.
+const shardResyncInterval = maxBackoffInterval
+
+// ShardLockName returns the name of the lock for shard of lockName.
+func ShardLockName(lockName string, shard int) string {
+	return fmt.Sprintf("%s-%d", lockName, shard)
+}
+
+// BecomeShards runs Leader For Life over shards locks named by ShardLockName,
+// so that the work of an operator can be split among its replicas. Each
+// shard lock is a ConfigMap owned by the pod holding the shard, exactly like
+// the lock created by Become.
+//
+// The current pod claims free shards until it holds its fair share, which is
+// the number of shards divided by the number of running pods managed by the
+// same controller (e.g. ReplicaSet or StatefulSet) as the current pod, rounded
+// up. When a peer dies, its shards become free once their locks are garbage
+// collected (or immediately if the peer was evicted or its node is not ready),
+// and are claimed by the surviving pods on their next pass. When a peer joins,
+// pods holding more than their fair share release their extra shards by
+// deleting their locks, so that the new peer can claim them.
+//
+// onChange is called with the sorted list of shards held by the current pod
+// whenever it changes, including when a lock held by the current pod is lost.
+// Before releasing shards, the current pod calls onChange with the shards it
+// keeps, so that it stops working on the released shards before another pod
+// claims them.
+//
+// BecomeShards blocks until ctx is done, in which case it returns nil. It
+// returns an error if the current pod cannot be determined or the API server
+// returns an unexpected error.
+//
+// The Checker, leader label and leader condition options only apply to
+// Become, and are ignored by BecomeShards.
+func BecomeShards(ctx context.Context, lockName string, shards int, onChange func(owned []int), opts ...Option) error {
+	if shards < 1 {
+		return fmt.Errorf("number of shards must be positive, got %d", shards)
+	}
+	config, err := newConfig(opts...)
+	if err != nil {
+		return err
+	}
+	if config.OffCluster {
+		return fmt.Errorf("BecomeShards does not support off-cluster mode")
+	}
+	if err := config.becomeShards(ctx, lockName, shards, onChange); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		config.notify(Event{Type: EventError, LockName: lockName, Err: err})
+		return err
+	}
+	return nil
+}
+
+func (c *Config) becomeShards(ctx context.Context, lockName string, shards int, onChange func(owned []int)) error {
+	ns, err := c.namespace()
+	if err != nil {
+		return err
+	}
+	if c.Preflight {
+		if err := c.preflight(ctx, c.shardPermissions(ns)); err != nil {
+			return err
+		}
+	}
+	myPod, err := c.currentPod(ctx, ns)
+	if err != nil {
+		return err
+	}
+	if err := c.resolveIdentity(myPod); err != nil {
+		return err
+	}
+
+	var owned []int
+	changed := func(current []int) {
+		if !equalShards(owned, current) {
+			log.Info("Shards held by this pod changed.", "shards", current)
+			owned = current
+			if onChange != nil {
+				onChange(owned)
+			}
+		}
+	}
+	for {
+		current, err := c.claimShards(ctx, ns, lockName, shards, myPod, changed)
+		if err != nil {
+			return err
+		}
+		changed(current)
+
+		select {
+		case <-c.clock().After(wait.Jitter(shardResyncInterval, .2)):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// claimShards returns the shards held by myPod, after claiming free shards up
+// to its fair share and replacing dead holders of other shards. If myPod holds
+// more than its fair share, release is called with the shards it keeps, if not
+// nil, before the extra shards are released.
+func (c *Config) claimShards(ctx context.Context, ns, lockName string, shards int, myPod *corev1.Pod,
+	release func(kept []int)) ([]int, error) {
+	peers, err := countPeers(ctx, c.Client, myPod)
+	if err != nil {
+		return nil, err
+	}
+	fairShare := (shards + peers - 1) / peers
+
+	owner := podOwnerRef(myPod)
+	owned := []int{}
+	free := []int{}
+	locks := map[int]*corev1.ConfigMap{}
+
+	// Start at an offset derived from the pod name, so that replicas starting
+	// at the same time contend for different shards.
+	start := shardOffset(myPod.Name, shards)
+	for n := 0; n < shards; n++ {
+		shard := (start + n) % shards
+		lock := &corev1.ConfigMap{}
+		key := crclient.ObjectKey{Namespace: ns, Name: ShardLockName(lockName, shard)}
+		err := c.Client.Get(ctx, key, lock)
+		switch {
+		case apierrors.IsNotFound(err):
+			free = append(free, shard)
+		case err != nil:
+			return nil, err
+		case isOwnedBy(lock, owner):
+			owned = append(owned, shard)
+			locks[shard] = lock
+		default:
+			if owners := lock.GetOwnerReferences(); len(owners) == 1 && owners[0].Kind == "Pod" {
+				_, health, err := getLeaderPod(ctx, c.Client, ns, owners[0])
+				if err != nil {
+					return nil, err
+				}
+				if health == LeaderHealthy && !c.isOlderLeader(lock) {
+					continue
+				}
+			}
+			adopted, err := c.handleExistingLock(ctx, lock, myPod)
+			if err != nil {
+				return nil, err
+			}
+			if adopted {
+				owned = append(owned, shard)
+			}
+		}
+	}
+
+	for _, shard := range free {
+		if len(owned) >= fairShare {
+			break
+		}
+		lock := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            ShardLockName(lockName, shard),
+				Namespace:       ns,
+				Labels:          lockLabels(),
+				OwnerReferences: []metav1.OwnerReference{*owner},
+			},
+			Data: c.holderData(myPod),
+		}
+		err := c.Client.Create(ctx, lock)
+		switch {
+		case err == nil:
+			log.Info("Became the leader of shard.", "shard", shard)
+			c.notify(Event{Type: EventBecameLeader, Namespace: ns, LockName: lock.Name, Leader: myPod.Name})
+			owned = append(owned, shard)
+		case apierrors.IsAlreadyExists(err):
+			// another pod claimed it first
+		default:
+			return nil, err
+		}
+	}
+
+	if len(owned) > fairShare {
+		return c.releaseShards(ctx, owned, fairShare, locks, release)
+	}
+	sort.Ints(owned)
+	return owned, nil
+}
+
+// releaseShards releases the shards of owned beyond the first fairShare, whose
+// locks are found in locks, and returns the shards still held. Shards adopted
+// during the current pass have no lock in locks, and are kept.
+func (c *Config) releaseShards(ctx context.Context, owned []int, fairShare int, locks map[int]*corev1.ConfigMap,
+	release func(kept []int)) ([]int, error) {
+	kept := []int{}
+	var extra []*corev1.ConfigMap
+	for _, shard := range owned {
+		if len(kept) < fairShare || locks[shard] == nil {
+			kept = append(kept, shard)
+		} else {
+			extra = append(extra, locks[shard])
+		}
+	}
+	sort.Ints(kept)
+	if release != nil {
+		release(kept)
+	}
+
+	for _, lock := range extra {
+		// The precondition makes the deletion fail if the lock was recreated
+		// by another pod in the meantime.
+		err := c.Client.Delete(ctx, lock, crclient.Preconditions{UID: &lock.UID})
+		if err != nil && !apierrors.IsNotFound(err) && !apierrors.IsConflict(err) {
+			return nil, err
+		}
+		log.Info("Released shard to rebalance shards among peers.", "lock", lock.Name)
+	}
+	return kept, nil
+}
+
+// countPeers returns the number of running pods, including pod itself, that
+// are managed by the same controller as pod.
+func countPeers(ctx context.Context, client crclient.Client, pod *corev1.Pod) (int, error) {
+	others, err := listPeers(ctx, client, pod)
+	if err != nil {
+		return 0, err
+	}
+	peers := 1
+	for i := range others {
+		p := &others[i]
+		if p.GetDeletionTimestamp() != nil {
+			continue
+		}
+		if p.Status.Phase == corev1.PodFailed || p.Status.Phase == corev1.PodSucceeded {
+			continue
+		}
+		peers++
+	}
+	return peers, nil
+}
+
+// listPeers returns the pods other than pod that are managed by the same
+// controller as pod, i.e. the other candidates of the elections pod takes part
+// in. A pod without a controller has no known peers.
+func listPeers(ctx context.Context, client crclient.Client, pod *corev1.Pod) ([]corev1.Pod, error) {
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil {
+		return nil, nil
+	}
+	pods := &corev1.PodList{}
+	if err := client.List(ctx, pods, crclient.InNamespace(pod.Namespace)); err != nil {
+		return nil, err
+	}
+	var peers []corev1.Pod
+	for i := range pods.Items {
+		p := &pods.Items[i]
+		if p.UID == pod.UID && p.Name == pod.Name {
+			continue
+		}
+		if peerRef := metav1.GetControllerOf(p); peerRef != nil && peerRef.UID == ref.UID {
+			peers = append(peers, *p)
+		}
+	}
+	return peers, nil
+}
+
+// isOwnedBy returns true if lock is owned by owner.
+func isOwnedBy(lock *corev1.ConfigMap, owner *metav1.OwnerReference) bool {
+	for _, ref := range lock.GetOwnerReferences() {
+		if ref.Name == owner.Name && ref.UID == owner.UID {
+			return true
+		}
+	}
+	return false
+}
+
+func shardOffset(podName string, shards int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(podName))
+	return int(h.Sum32() % uint32(shards))
+}
+
+// equalShards returns true if a and b hold the same shards. A nil list,
+// meaning no pass has completed yet, differs from an empty one.
+func equalShards(a, b []int) bool {
+	if (a == nil) != (b == nil) || len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,169 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leader
+
+import (
+	"context"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Sharded leader election", func() {
+	var (
+		client crclient.Client
+		podA   *corev1.Pod
+		podB   *corev1.Pod
+		config *Config
+	)
+	newPod := func(name string) *corev1.Pod {
+		controller := true
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "testns",
+				UID:       types.UID(name + "-uid"),
+				OwnerReferences: []metav1.OwnerReference{
+					{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "operator", UID: "rs-uid", Controller: &controller},
+				},
+			},
+		}
+	}
+	BeforeEach(func() {
+		readNamespace = func() (string, error) {
+			return "testns", nil
+		}
+		podA = newPod("operator-a")
+		podB = newPod("operator-b")
+		client = fake.NewClientBuilder().WithObjects(podA, podB).Build()
+		config = &Config{Client: client}
+	})
+
+	It("should split shards fairly between replicas", func() {
+		ownedA, err := config.claimShards(context.TODO(), "testns", "shard-test", 5, podA, nil)
+		Expect(err).Should(BeNil())
+		Expect(ownedA).To(HaveLen(3))
+
+		ownedB, err := config.claimShards(context.TODO(), "testns", "shard-test", 5, podB, nil)
+		Expect(err).Should(BeNil())
+		Expect(ownedB).To(HaveLen(2))
+		Expect(append(ownedA, ownedB...)).To(ConsistOf(0, 1, 2, 3, 4))
+
+		again, err := config.claimShards(context.TODO(), "testns", "shard-test", 5, podA, nil)
+		Expect(err).Should(BeNil())
+		Expect(again).To(Equal(ownedA))
+	})
+	It("should pick up orphaned shards when a peer dies", func() {
+		ownedA, err := config.claimShards(context.TODO(), "testns", "shard-test", 4, podA, nil)
+		Expect(err).Should(BeNil())
+		Expect(ownedA).To(HaveLen(2))
+
+		// simulate the garbage collector removing podA's locks
+		Expect(client.Delete(context.TODO(), podA)).To(Succeed())
+		for _, shard := range ownedA {
+			lock := &corev1.ConfigMap{}
+			Expect(client.Get(context.TODO(), crclient.ObjectKey{Namespace: "testns", Name: ShardLockName("shard-test", shard)}, lock)).To(Succeed())
+			Expect(client.Delete(context.TODO(), lock)).To(Succeed())
+		}
+
+		ownedB, err := config.claimShards(context.TODO(), "testns", "shard-test", 4, podB, nil)
+		Expect(err).Should(BeNil())
+		Expect(ownedB).To(ConsistOf(0, 1, 2, 3))
+	})
+	It("should release extra shards when a peer joins", func() {
+		Expect(client.Delete(context.TODO(), podB)).To(Succeed())
+		ownedA, err := config.claimShards(context.TODO(), "testns", "shard-test", 4, podA, nil)
+		Expect(err).Should(BeNil())
+		Expect(ownedA).To(ConsistOf(0, 1, 2, 3))
+
+		podC := newPod("operator-c")
+		Expect(client.Create(context.TODO(), podC)).To(Succeed())
+		var kept []int
+		ownedA, err = config.claimShards(context.TODO(), "testns", "shard-test", 4, podA, func(k []int) {
+			kept = k
+			// the shards are released only after the pod was told to stop
+			// working on them
+			for shard := 0; shard < 4; shard++ {
+				Expect(client.Get(context.TODO(), crclient.ObjectKey{Namespace: "testns",
+					Name: ShardLockName("shard-test", shard)}, &corev1.ConfigMap{})).To(Succeed())
+			}
+		})
+		Expect(err).Should(BeNil())
+		Expect(ownedA).To(HaveLen(2))
+		Expect(kept).To(Equal(ownedA))
+
+		ownedC, err := config.claimShards(context.TODO(), "testns", "shard-test", 4, podC, nil)
+		Expect(err).Should(BeNil())
+		Expect(ownedC).To(HaveLen(2))
+		Expect(append(ownedA, ownedC...)).To(ConsistOf(0, 1, 2, 3))
+
+		again, err := config.claimShards(context.TODO(), "testns", "shard-test", 4, podA, nil)
+		Expect(err).Should(BeNil())
+		Expect(again).To(Equal(ownedA))
+	})
+	It("should take over shards of an evicted peer", func() {
+		ownedA, err := config.claimShards(context.TODO(), "testns", "shard-test", 2, podA, nil)
+		Expect(err).Should(BeNil())
+		Expect(ownedA).To(HaveLen(1))
+
+		podA.Status.Phase = corev1.PodFailed
+		podA.Status.Reason = "Evicted"
+		Expect(client.Status().Update(context.TODO(), podA)).To(Succeed())
+
+		// the first pass deletes the evicted pod, the garbage collector then
+		// removes its lock
+		_, err = config.claimShards(context.TODO(), "testns", "shard-test", 2, podB, nil)
+		Expect(err).Should(BeNil())
+		lock := &corev1.ConfigMap{}
+		Expect(client.Get(context.TODO(), crclient.ObjectKey{Namespace: "testns", Name: ShardLockName("shard-test", ownedA[0])}, lock)).To(Succeed())
+		Expect(client.Delete(context.TODO(), lock)).To(Succeed())
+
+		ownedB, err := config.claimShards(context.TODO(), "testns", "shard-test", 2, podB, nil)
+		Expect(err).Should(BeNil())
+		Expect(ownedB).To(ConsistOf(0, 1))
+	})
+
+	Describe("BecomeShards", func() {
+		It("should reject a non-positive number of shards", func() {
+			Expect(BecomeShards(context.TODO(), "shard-test", 0, nil)).ShouldNot(Succeed())
+		})
+		It("should notify the shards held until the context is done", func() {
+			os.Setenv("POD_NAME", "operator-a")
+			var notified [][]int
+			ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+			defer cancel()
+			var errs []error
+			observer := ObserverFunc(func(e Event) {
+				if e.Type == EventError {
+					errs = append(errs, e.Err)
+				}
+			})
+			err := BecomeShards(ctx, "shard-test", 4, func(owned []int) {
+				notified = append(notified, owned)
+			}, WithClient(client), WithObserver(observer))
+			Expect(err).Should(BeNil())
+			Expect(errs).To(BeEmpty())
+			Expect(notified).To(HaveLen(1))
+			Expect(notified[0]).To(HaveLen(2))
+		})
+	})
+})
@@ -0,0 +1,91 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leader
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/version"
+)
+
+// WithVersionHandoff returns an Option that makes leadership converge on the
+// newest operator version during upgrades. It requires WithOperatorVersion to
+// be given a semantic version.
+//
+// A candidate waiting on a healthy leader that recorded an older version
+// deletes the leader pod, and becomes the leader once the garbage collector
+// removes the lock after the pod is gone, so that the old and new leaders never
+// run at the same time. A candidate never replaces a leader with the same or a
+// newer version, or one that did not record a valid semantic version, so an
+// older candidate yields to newer leaders.
+func WithVersionHandoff() Option {
+	return func(c *Config) error {
+		c.VersionHandoff = true
+		return nil
+	}
+}
+
+// validateVersion returns an error if version handoff is enabled without a
+// valid semantic operator version.
+func (c *Config) validateVersion() error {
+	if !c.VersionHandoff {
+		return nil
+	}
+	if _, err := version.ParseSemantic(c.OperatorVersion); err != nil {
+		return fmt.Errorf("version handoff requires a semantic operator version: %w", err)
+	}
+	return nil
+}
+
+// isOlderLeader returns true if version handoff is enabled and lock records an
+// operator version lower than the current pod's.
+func (c *Config) isOlderLeader(lock *corev1.ConfigMap) bool {
+	if !c.VersionHandoff || c.disabled[featureVersionHandoff] {
+		return false
+	}
+	leaderVersion, err := version.ParseSemantic(lock.Data[operatorVersionKey])
+	if err != nil {
+		return false
+	}
+	myVersion, err := version.ParseSemantic(c.OperatorVersion)
+	if err != nil {
+		return false
+	}
+	return leaderVersion.LessThan(myVersion)
+}
+
+// replaceOlderLeader deletes leaderPod, unless it is already being deleted.
+// The lock is not taken over: it is removed by the garbage collector once the
+// leader pod is actually gone, so that the old leader stops reconciling during
+// its termination grace period before another pod becomes the leader.
+func (c *Config) replaceOlderLeader(ctx context.Context, leaderPod *corev1.Pod, lock *corev1.ConfigMap) error {
+	if leaderPod.GetDeletionTimestamp() != nil {
+		log.Info("Leader running an older operator version is terminating. Waiting.", "leader", leaderPod.Name)
+		return nil
+	}
+	log.Info("Leader runs an older operator version. Deleting it.", "leader", leaderPod.Name,
+		"leaderVersion", lock.Data[operatorVersionKey], "version", c.OperatorVersion)
+	if err := c.Client.Delete(ctx, leaderPod); err != nil && !apierrors.IsNotFound(err) {
+		log.Error(err, "Leader pod could not be deleted.")
+		return err
+	}
+	c.recordTakeover(lock, leaderPod, ReasonOlderLeaderReplaced,
+		fmt.Sprintf("Deleted leader pod %s running version %s in favor of version %s",
+			leaderPod.Name, lock.Data[operatorVersionKey], c.OperatorVersion))
+	return nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package leader
+
+import (
+	"context"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Version handoff", func() {
+	var (
+		client    crclient.Client
+		myPod     *corev1.Pod
+		leaderPod *corev1.Pod
+		lock      *corev1.ConfigMap
+	)
+	BeforeEach(func() {
+		os.Setenv("POD_NAME", "leader-test")
+		readNamespace = func() (string, error) {
+			return "testns", nil
+		}
+		myPod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "leader-test", Namespace: "testns", UID: "my-uid"},
+		}
+		leaderPod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "old-leader", Namespace: "testns", UID: "old-uid"},
+		}
+		lock = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "leader-test",
+				Namespace: "testns",
+				OwnerReferences: []metav1.OwnerReference{
+					{APIVersion: "v1", Kind: "Pod", Name: "old-leader", UID: "old-uid"},
+				},
+			},
+			Data: map[string]string{operatorVersionKey: "v1.2.0"},
+		}
+	})
+	become := func(opts ...Option) error {
+		client = fake.NewClientBuilder().WithObjects(myPod, leaderPod, lock).Build()
+		ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+		defer cancel()
+		return Become(ctx, "leader-test", append(opts, WithClient(client))...)
+	}
+
+	It("should require a semantic operator version", func() {
+		err := Become(context.TODO(), "leader-test", WithVersionHandoff(), WithOperatorVersion("latest"))
+		Expect(err).ShouldNot(BeNil())
+	})
+	It("should replace a leader running an older version once its lock is gone", func() {
+		err := become(WithOperatorVersion("v1.10.0"), WithVersionHandoff())
+		Expect(err).To(Equal(context.DeadlineExceeded))
+
+		// the old leader may still be terminating, so its lock is left to the
+		// garbage collector
+		err = client.Get(context.TODO(), crclient.ObjectKey{Namespace: "testns", Name: "old-leader"}, &corev1.Pod{})
+		Expect(apierrors.IsNotFound(err)).To(BeTrue())
+		info, err := GetLeader(context.TODO(), client, "testns", "leader-test")
+		Expect(err).Should(BeNil())
+		Expect(info.PodName).To(Equal("old-leader"))
+
+		// simulate the garbage collector removing the lock
+		Expect(client.Delete(context.TODO(), lock)).To(Succeed())
+		err = Become(context.TODO(), "leader-test", WithClient(client), WithOperatorVersion("v1.10.0"), WithVersionHandoff())
+		Expect(err).Should(BeNil())
+		info, err = GetLeader(context.TODO(), client, "testns", "leader-test")
+		Expect(err).Should(BeNil())
+		Expect(info.PodName).To(Equal("leader-test"))
+		Expect(info.OperatorVersion).To(Equal("v1.10.0"))
+	})
+	It("should not delete a terminating older leader again", func() {
+		now := metav1.Now()
+		leaderPod.DeletionTimestamp = &now
+		leaderPod.Finalizers = []string{"example.com/hold"}
+		err := become(WithOperatorVersion("v1.10.0"), WithVersionHandoff())
+		Expect(err).To(Equal(context.DeadlineExceeded))
+		Expect(client.Get(context.TODO(), crclient.ObjectKey{Namespace: "testns", Name: "old-leader"}, &corev1.Pod{})).To(Succeed())
+	})
+	It("should not replace a leader running a newer version", func() {
+		lock.Data[operatorVersionKey] = "v2.0.0"
+		err := become(WithOperatorVersion("v1.10.0"), WithVersionHandoff())
+		Expect(err).To(Equal(context.DeadlineExceeded))
+	})
+	It("should not replace a leader that did not record a version", func() {
+		delete(lock.Data, operatorVersionKey)
+		err := become(WithOperatorVersion("v1.10.0"), WithVersionHandoff())
+		Expect(err).To(Equal(context.DeadlineExceeded))
+	})
+	It("should not replace an older leader without version handoff", func() {
+		err := become(WithOperatorVersion("v1.10.0"))
+		Expect(err).To(Equal(context.DeadlineExceeded))
+	})
+})
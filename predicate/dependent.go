@@ -19,6 +19,8 @@ import (
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
@@ -43,8 +45,10 @@ var _ predicate.Predicate = DependentPredicate{}
 //     primary resource is not deleted.
 //   - Generic events are ignored.
 //
-// DependentPredicate is most often used in conjunction with
-// controller-runtime's handler.EnqueueRequestForOwner
+// DependentPredicate accepts both typed and unstructured resources. It is
+// most often used in conjunction with controller-runtime's
+// handler.EnqueueRequestForOwner, and is the default predicate of
+// handler.WatchAnnotatedDependents.
 type DependentPredicate struct {
 	predicate.Funcs
 }
@@ -53,10 +57,10 @@ type DependentPredicate struct {
 // reconciling the parent is the only client creating the dependent
 // resources.
 func (DependentPredicate) Create(e event.CreateEvent) bool {
-	o := e.Object.(*unstructured.Unstructured)
+	o := e.Object
 	log.V(1).Info("Skipping reconciliation for dependent resource creation",
 		"name", o.GetName(), "namespace", o.GetNamespace(), "apiVersion",
-		o.GroupVersionKind().GroupVersion(), "kind", o.GroupVersionKind().Kind)
+		o.GetObjectKind().GroupVersionKind().GroupVersion(), "kind", o.GetObjectKind().GroupVersionKind().Kind)
 	return false
 }
 
@@ -64,19 +68,19 @@ func (DependentPredicate) Create(e event.CreateEvent) bool {
 // recreate deleted dependent resources if the primary resource is
 // not deleted.
 func (DependentPredicate) Delete(e event.DeleteEvent) bool {
-	o := e.Object.(*unstructured.Unstructured)
+	o := e.Object
 	log.V(1).Info("Reconciling due to dependent resource deletion",
 		"name", o.GetName(), "namespace", o.GetNamespace(), "apiVersion",
-		o.GroupVersionKind().GroupVersion(), "kind", o.GroupVersionKind().Kind)
+		o.GetObjectKind().GroupVersionKind().GroupVersion(), "kind", o.GetObjectKind().GroupVersionKind().Kind)
 	return true
 }
 
 // Generic filters out all events.
 func (DependentPredicate) Generic(e event.GenericEvent) bool {
-	o := e.Object.(*unstructured.Unstructured)
+	o := e.Object
 	log.V(1).Info("Skipping reconcile due to generic event", "name", o.GetName(),
-		"namespace", o.GetNamespace(), "apiVersion", o.GroupVersionKind().GroupVersion(),
-		"kind", o.GroupVersionKind().Kind)
+		"namespace", o.GetNamespace(), "apiVersion", o.GetObjectKind().GroupVersionKind().GroupVersion(),
+		"kind", o.GetObjectKind().GroupVersionKind().Kind)
 	return false
 }
 
@@ -84,8 +88,16 @@ func (DependentPredicate) Generic(e event.GenericEvent) bool {
 // status. It is not typical for the controller of a primary
 // resource to write to the status of one its dependent resources.
 func (DependentPredicate) Update(e event.UpdateEvent) bool {
-	old := e.ObjectOld.(*unstructured.Unstructured).DeepCopy()
-	new := e.ObjectNew.(*unstructured.Unstructured).DeepCopy()
+	old, err := toUnstructured(e.ObjectOld)
+	if err != nil {
+		log.Error(err, "Unable to convert dependent resource, reconciling")
+		return true
+	}
+	new, err := toUnstructured(e.ObjectNew)
+	if err != nil {
+		log.Error(err, "Unable to convert dependent resource, reconciling")
+		return true
+	}
 
 	delete(old.Object, "status")
 	delete(new.Object, "status")
@@ -104,6 +116,18 @@ func (DependentPredicate) Update(e event.UpdateEvent) bool {
 	return true
 }
 
+// toUnstructured returns a copy of obj as an unstructured object, converting it if it is typed.
+func toUnstructured(obj client.Object) (*unstructured.Unstructured, error) {
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		return u.DeepCopy(), nil
+	}
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return nil, err
+	}
+	return &unstructured.Unstructured{Object: content}, nil
+}
+
 func removeTimeFromManagedFields(fields []metav1.ManagedFieldsEntry) []metav1.ManagedFieldsEntry {
 	if fields == nil {
 		return nil
@@ -19,6 +19,7 @@ import (
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 )
@@ -33,6 +34,10 @@ var _ = Describe("DependentPredicate", func() {
 			e := makeCreateEventFor(&unstructured.Unstructured{})
 			Expect(pred.Create(e)).To(BeFalse())
 		})
+		It("returns false for typed objects", func() {
+			e := makeCreateEventFor(&corev1.Pod{})
+			Expect(pred.Create(e)).To(BeFalse())
+		})
 	})
 
 	Describe("Update", func() {
@@ -110,6 +115,26 @@ var _ = Describe("DependentPredicate", func() {
 				Expect(pred.Update(e)).To(BeTrue())
 			})
 		})
+
+		When("objects are typed", func() {
+			var oldPod, newPod *corev1.Pod
+			BeforeEach(func() {
+				oldPod = &corev1.Pod{ObjectMeta: metav1.ObjectMeta{UID: "A", ResourceVersion: "1"}}
+				newPod = oldPod.DeepCopy()
+				newPod.ResourceVersion = "2"
+			})
+
+			It("should return false if only the status is different", func() {
+				newPod.Status.Phase = corev1.PodRunning
+				e := makeUpdateEventFor(oldPod, newPod)
+				Expect(pred.Update(e)).To(BeFalse())
+			})
+			It("should return true if the spec is different", func() {
+				newPod.Spec.NodeName = "node"
+				e := makeUpdateEventFor(oldPod, newPod)
+				Expect(pred.Update(e)).To(BeTrue())
+			})
+		})
 	})
 
 	Describe("Delete", func() {
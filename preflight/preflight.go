@@ -0,0 +1,188 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package preflight checks that an operator has the permissions the features
+// it uses need, before it discovers a missing permission mid-flight.
+//
+// Packages of this library describe the permissions of their features, e.g.
+// leader.Permissions and conditions.Permissions. Check runs a
+// SelfSubjectAccessReview for each of them, and returns a Report that tells
+// which are missing:
+//
+//	perms, err := leader.Permissions(ns)
+//	...
+//	report, err := preflight.Check(ctx, client, perms...)
+//	...
+//	if err := report.Err(); err != nil {
+//		// a required permission is missing
+//	}
+package preflight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ErrMissingPermissions indicates that a required permission is missing.
+var ErrMissingPermissions = errors.New("missing required permissions")
+
+// Permission is an action that an operator needs to be allowed to perform.
+type Permission struct {
+	// Verb, Group, Resource, Subresource, Namespace and Name describe the
+	// action, as in a SelfSubjectAccessReview. Namespace is empty for
+	// cluster-scoped resources, and Name is empty for any object.
+	Verb        string
+	Group       string
+	Resource    string
+	Subresource string
+	Namespace   string
+	Name        string
+
+	// Feature describes the feature that needs the permission.
+	Feature string
+
+	// Optional is true if the feature can be skipped, or degraded, when the
+	// permission is missing.
+	Optional bool
+}
+
+// String returns a description of the action, e.g. "delete pods in testns".
+func (p Permission) String() string {
+	var b strings.Builder
+	b.WriteString(p.Verb)
+	b.WriteString(" ")
+	b.WriteString(p.Resource)
+	if p.Group != "" {
+		b.WriteString(".")
+		b.WriteString(p.Group)
+	}
+	if p.Subresource != "" {
+		b.WriteString("/")
+		b.WriteString(p.Subresource)
+	}
+	if p.Name != "" {
+		b.WriteString(" ")
+		b.WriteString(p.Name)
+	}
+	if p.Namespace != "" {
+		b.WriteString(" in ")
+		b.WriteString(p.Namespace)
+	}
+	return b.String()
+}
+
+// Result is the outcome of checking a Permission.
+type Result struct {
+	Permission
+	// Allowed is true if the operator has the permission.
+	Allowed bool
+	// Reason is the reason given by the authorizer, if any.
+	Reason string
+}
+
+// Report is the outcome of checking a set of permissions.
+type Report struct {
+	Results []Result
+}
+
+// Allowed returns true if p was checked and is allowed. Permissions are
+// compared by action only; Feature and Optional are ignored.
+func (r *Report) Allowed(p Permission) bool {
+	i := r.index(p)
+	return i >= 0 && r.Results[i].Allowed
+}
+
+// Missing returns the results of the permissions that are not allowed.
+func (r *Report) Missing() []Result {
+	var missing []Result
+	for _, res := range r.Results {
+		if !res.Allowed {
+			missing = append(missing, res)
+		}
+	}
+	return missing
+}
+
+// Err returns an error wrapping ErrMissingPermissions that lists the missing
+// required permissions, or nil if none is missing.
+func (r *Report) Err() error {
+	var missing []string
+	for _, res := range r.Missing() {
+		if !res.Optional {
+			missing = append(missing, fmt.Sprintf("%s (%s)", res.Permission, res.Feature))
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrMissingPermissions, strings.Join(missing, ", "))
+}
+
+// Check runs a SelfSubjectAccessReview for each of perms, and returns a
+// Report of the results. Permissions describing the same action are only
+// checked once, and are required if any of them is. It returns an error if a
+// review cannot be created.
+func Check(ctx context.Context, client crclient.Client, perms ...Permission) (*Report, error) {
+	report := &Report{}
+	for _, p := range perms {
+		if i := report.index(p); i >= 0 {
+			if !p.Optional {
+				report.Results[i].Optional = false
+				report.Results[i].Feature = p.Feature
+			}
+			continue
+		}
+		review := &authorizationv1.SelfSubjectAccessReview{
+			Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Namespace:   p.Namespace,
+					Verb:        p.Verb,
+					Group:       p.Group,
+					Resource:    p.Resource,
+					Subresource: p.Subresource,
+					Name:        p.Name,
+				},
+			},
+		}
+		if err := client.Create(ctx, review); err != nil {
+			return nil, fmt.Errorf("unable to review permission to %s: %w", p, err)
+		}
+		report.Results = append(report.Results, Result{
+			Permission: p,
+			Allowed:    review.Status.Allowed,
+			Reason:     review.Status.Reason,
+		})
+	}
+	return report, nil
+}
+
+// index returns the index of the result of p, or -1 if p was not checked.
+func (r *Report) index(p Permission) int {
+	for i, res := range r.Results {
+		if sameAction(res.Permission, p) {
+			return i
+		}
+	}
+	return -1
+}
+
+func sameAction(a, b Permission) bool {
+	return a.Verb == b.Verb && a.Group == b.Group && a.Resource == b.Resource &&
+		a.Subresource == b.Subresource && a.Namespace == b.Namespace && a.Name == b.Name
+}
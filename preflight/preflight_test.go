@@ -0,0 +1,87 @@
+// Copyright 2021 The Operator-SDK Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package preflight
+
+import (
+	"context"
+	"errors"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	crclient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// reviewClient answers SelfSubjectAccessReviews, allowing the verbs on the
+// resources in allowed.
+type reviewClient struct {
+	crclient.Client
+	allowed map[string]bool
+	reviews int
+}
+
+func (c *reviewClient) Create(ctx context.Context, obj crclient.Object, opts ...crclient.CreateOption) error {
+	review, ok := obj.(*authorizationv1.SelfSubjectAccessReview)
+	if !ok {
+		return c.Client.Create(ctx, obj, opts...)
+	}
+	c.reviews++
+	attrs := review.Spec.ResourceAttributes
+	review.Status.Allowed = c.allowed[attrs.Verb+" "+attrs.Resource]
+	return nil
+}
+
+var _ = Describe("Check", func() {
+	var client *reviewClient
+	getPods := Permission{Verb: "get", Resource: "pods", Namespace: "testns", Feature: "election"}
+	getNodes := Permission{Verb: "get", Resource: "nodes", Feature: "node checks", Optional: true}
+	BeforeEach(func() {
+		client = &reviewClient{
+			Client:  fake.NewClientBuilder().Build(),
+			allowed: map[string]bool{"get pods": true},
+		}
+	})
+
+	It("should report allowed and missing permissions", func() {
+		report, err := Check(context.TODO(), client, getPods, getNodes)
+		Expect(err).Should(BeNil())
+		Expect(report.Allowed(getPods)).To(BeTrue())
+		Expect(report.Allowed(getNodes)).To(BeFalse())
+		Expect(report.Missing()).To(HaveLen(1))
+		Expect(report.Missing()[0].Permission).To(Equal(getNodes))
+	})
+	It("should only fail on missing required permissions", func() {
+		report, err := Check(context.TODO(), client, getPods, getNodes)
+		Expect(err).Should(BeNil())
+		Expect(report.Err()).To(Succeed())
+
+		client.allowed = map[string]bool{}
+		report, err = Check(context.TODO(), client, getPods, getNodes)
+		Expect(err).Should(BeNil())
+		Expect(errors.Is(report.Err(), ErrMissingPermissions)).To(BeTrue())
+		Expect(report.Err().Error()).To(ContainSubstring("get pods in testns (election)"))
+		Expect(report.Err().Error()).NotTo(ContainSubstring("nodes"))
+	})
+	It("should review each action once, requiring it if any feature does", func() {
+		client.allowed = map[string]bool{}
+		optional := getPods
+		optional.Optional = true
+		report, err := Check(context.TODO(), client, optional, getPods)
+		Expect(err).Should(BeNil())
+		Expect(client.reviews).To(Equal(1))
+		Expect(report.Err()).To(HaveOccurred())
+	})
+})